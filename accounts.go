@@ -0,0 +1,80 @@
+// accounts.go - mixnet client account enumeration
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/katzenpost/core/crypto/ecdh"
+)
+
+// AccountInfo describes an account of the Client.
+type AccountInfo struct {
+	// User is the account name on the Provider.
+	User string
+
+	// Provider is the name of the account's Provider.
+	Provider string
+
+	// LinkKey is the public link key the account authenticates with.
+	LinkKey *ecdh.PublicKey
+
+	// LinkKeyFingerprint is the hex encoded SHA-256 digest of LinkKey,
+	// suitable for comparing keys out of band.
+	LinkKeyFingerprint string
+
+	// CreatedAt is when the account's session was created.
+	CreatedAt time.Time
+
+	// Ephemeral is true for accounts created by NewEphemeralAccount.
+	Ephemeral bool
+}
+
+// Fingerprint returns the hex encoded SHA-256 digest of a public key.
+func Fingerprint(key *ecdh.PublicKey) string {
+	digest := sha256.Sum256(key.Bytes())
+	return hex.EncodeToString(digest[:])
+}
+
+// Info returns the description of the Session's account.
+func (s *Session) Info() *AccountInfo {
+	linkKey := s.linkKey.PublicKey()
+	return &AccountInfo{
+		User:               s.cfg.Account.User,
+		Provider:           s.cfg.Account.Provider,
+		LinkKey:            linkKey,
+		LinkKeyFingerprint: Fingerprint(linkKey),
+		CreatedAt:          s.createdAt,
+	}
+}
+
+// Accounts returns the description of every account with a session,
+// the Client's own account first followed by any ephemeral accounts.
+func (c *Client) Accounts() []*AccountInfo {
+	accounts := []*AccountInfo{}
+	if s := c.getSession(); s != nil {
+		accounts = append(accounts, s.Info())
+	}
+	for _, s := range c.ephemeralSessions() {
+		info := s.Info()
+		info.Ephemeral = true
+		accounts = append(accounts, info)
+	}
+	return accounts
+}
@@ -0,0 +1,56 @@
+// accounts_test.go - mixnet client account enumeration tests
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccounts(t *testing.T) {
+	require := require.New(t)
+
+	clock := NewManualClock(epoch0)
+	c := &Client{ephemeral: map[*Session]*time.Timer{}}
+	require.Empty(c.Accounts())
+
+	s1 := testSession(t, clock, new(fakeMinclient))
+	defer haltTestSession(s1)
+	s2 := testSession(t, clock, new(fakeMinclient))
+	defer haltTestSession(s2)
+	c.session = s1
+	c.ephemeral[s2] = nil
+
+	accounts := c.Accounts()
+	require.Len(accounts, 2)
+	require.Equal("alice", accounts[0].User)
+	require.Equal("acme", accounts[0].Provider)
+	require.Equal(epoch0, accounts[0].CreatedAt)
+	require.False(accounts[0].Ephemeral)
+	require.True(accounts[1].Ephemeral)
+
+	linkKey := s1.linkKey.PublicKey()
+	digest := sha256.Sum256(linkKey.Bytes())
+	require.Equal(linkKey.Bytes(), accounts[0].LinkKey.Bytes())
+	require.Equal(hex.EncodeToString(digest[:]), accounts[0].LinkKeyFingerprint)
+	require.Equal(Fingerprint(s2.linkKey.PublicKey()), accounts[1].LinkKeyFingerprint)
+	require.NotEqual(accounts[0].LinkKeyFingerprint, accounts[1].LinkKeyFingerprint)
+}
@@ -0,0 +1,81 @@
+// activity.go - mixnet client activity window
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	mrand "math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/pki"
+)
+
+const (
+	// activityCheckInterval is how often a dormant Session checks
+	// whether its activity window has opened.
+	activityCheckInterval = time.Minute
+
+	// dormantPollInterval is the interval the Provider is polled at
+	// outside of the activity window.
+	dormantPollInterval = 24 * time.Hour
+)
+
+// activityFactor returns the fraction of the normal traffic rate the
+// Session is currently active at.
+func (s *Session) activityFactor() float64 {
+	if s.cfg.ActivityWindow == nil {
+		return 1
+	}
+	return s.cfg.ActivityWindow.Factor(s.clock.Now())
+}
+
+// activityInterval returns the delay until the next event of a Poisson
+// process with rate lambda, scaled by the activity factor.
+func activityInterval(mRng *mrand.Rand, lambda float64, maxDelay uint64, factor float64) time.Duration {
+	if factor == 0 {
+		return activityCheckInterval
+	}
+	msec := uint64(rand.Exp(mRng, lambda*factor))
+	if max := uint64(float64(maxDelay) / factor); msec > max {
+		msec = max
+	}
+	return time.Duration(msec) * time.Millisecond
+}
+
+func (s *Session) isDormant() bool {
+	return atomic.LoadUint32(&s.dormant) != 0
+}
+
+// setDormant stops polling the Provider while the Session is outside of
+// its activity window, and restores polling when it opens.
+func (s *Session) setDormant(dormant bool, doc *pki.Document) {
+	var v uint32
+	if dormant {
+		v = 1
+	}
+	if atomic.SwapUint32(&s.dormant, v) == v {
+		return
+	}
+	if dormant {
+		s.log.Notice("Activity window closed, pausing network activity.")
+		s.setPollInterval(dormantPollInterval)
+	} else {
+		s.log.Notice("Activity window opened, resuming network activity.")
+		s.setPollIntervalFromDoc(doc)
+	}
+}
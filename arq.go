@@ -0,0 +1,134 @@
+// arq.go - mixnet client retransmission state persistence
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"time"
+
+	cConstants "github.com/katzenpost/client/constants"
+)
+
+// ARQEntry is the retransmission state of a reliable message which has
+// been sent and is awaiting its reply.
+type ARQEntry struct {
+	// MessageID is the message identifier.
+	MessageID [cConstants.MessageIDLength]byte
+
+	// Recipient and Provider are the message's destination.
+	Recipient string
+	Provider  string
+
+	// Payload is the message payload.
+	Payload []byte
+
+	// Expiry is when the message will be retransmitted if no reply
+	// has arrived.
+	Expiry time.Time
+
+	// Retransmissions is the number of times the message has been
+	// retransmitted.
+	Retransmissions uint32
+}
+
+// ARQStore persists the retransmission state of reliable messages, so
+// that messages in flight are not forgotten across restarts.  Messages
+// still waiting in the egress queue are not in flight and are never
+// stored, so an ARQStore used with a durable EgressQueue does not cause
+// messages to be sent twice.
+type ARQStore interface {
+	// Put stores the entry, replacing any entry for the same message.
+	Put(*ARQEntry) error
+
+	// Delete removes the entry for the message, if any.
+	Delete(messageID *[cConstants.MessageIDLength]byte) error
+
+	// Load returns every stored entry.
+	Load() ([]*ARQEntry, error)
+}
+
+// arqPut stores the retransmission state of a reliable message.  Only
+// the original of redundant copies is stored, as they share an ID.
+func (s *Session) arqPut(msg *Message) {
+	if s.arqStore == nil || msg.isCopy {
+		return
+	}
+	entry := &ARQEntry{
+		MessageID:       *msg.ID,
+		Recipient:       msg.Recipient,
+		Provider:        msg.Provider,
+		Payload:         append([]byte{}, msg.Payload...),
+		Expiry:          time.Unix(0, int64(msg.QueuePriority)),
+		Retransmissions: msg.Retransmissions,
+	}
+	if err := s.arqStore.Put(entry); err != nil {
+		s.log.Errorf("Failed to store retransmission state of message %x: %v", *msg.ID, err)
+	}
+}
+
+// arqDelete removes the retransmission state of a message which will no
+// longer be retransmitted.  A redundant copy only removes the original's
+// state once a copy has been replied to.
+func (s *Session) arqDelete(msg *Message) {
+	if s.arqStore == nil || !msg.Reliable {
+		return
+	}
+	if msg.isCopy && !msg.group.isReplied() {
+		return
+	}
+	if err := s.arqStore.Delete(msg.ID); err != nil {
+		s.log.Errorf("Failed to delete retransmission state of message %x: %v", *msg.ID, err)
+	}
+}
+
+// restoreARQ retransmits the reliable messages which were in flight when
+// the Session was last stopped.  Their SURB decryption keys were not
+// persisted, so replies to the earlier transmissions can not be read and
+// each message is sent again with a new SURB once its expiry has passed.
+func (s *Session) restoreARQ() error {
+	entries, err := s.arqStore.Load()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		id := entry.MessageID
+		msg := &Message{
+			ID:              &id,
+			Recipient:       entry.Recipient,
+			Provider:        entry.Provider,
+			Payload:         entry.Payload,
+			WithSURB:        true,
+			Reliable:        true,
+			Retransmissions: entry.Retransmissions,
+		}
+		delay := entry.Expiry.Sub(s.clock.Now())
+		s.log.Debugf("Restoring retransmission of message %x in %v", id, delay)
+		if delay <= 0 {
+			s.pushRetransmit(msg)
+			continue
+		}
+		timer := s.clock.NewTimer(delay)
+		s.Go(func() {
+			select {
+			case <-s.HaltCh():
+				timer.Stop()
+			case <-timer.C():
+				s.pushRetransmit(msg)
+			}
+		})
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// arq_test.go - mixnet client retransmission state persistence tests
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	cConstants "github.com/katzenpost/client/constants"
+	"github.com/stretchr/testify/require"
+)
+
+// memARQStore is an ARQStore kept in memory.
+type memARQStore struct {
+	sync.Mutex
+
+	m map[[cConstants.MessageIDLength]byte]*ARQEntry
+}
+
+func newMemARQStore() *memARQStore {
+	return &memARQStore{m: make(map[[cConstants.MessageIDLength]byte]*ARQEntry)}
+}
+
+func (m *memARQStore) Put(entry *ARQEntry) error {
+	m.Lock()
+	defer m.Unlock()
+	m.m[entry.MessageID] = entry
+	return nil
+}
+
+func (m *memARQStore) Delete(messageID *[cConstants.MessageIDLength]byte) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.m, *messageID)
+	return nil
+}
+
+func (m *memARQStore) Load() ([]*ARQEntry, error) {
+	m.Lock()
+	defer m.Unlock()
+	entries := []*ARQEntry{}
+	for _, entry := range m.m {
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (m *memARQStore) Len() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.m)
+}
+
+func TestRestoreARQ(t *testing.T) {
+	require := require.New(t)
+
+	clock := NewManualClock(epoch0)
+	s := testSession(t, clock, new(fakeMinclient))
+	defer haltTestSession(s)
+	store := newMemARQStore()
+	s.arqStore = store
+	require.NoError(store.Put(&ARQEntry{
+		MessageID:       [cConstants.MessageIDLength]byte{1},
+		Recipient:       "bob",
+		Provider:        "acme",
+		Payload:         []byte("overdue"),
+		Expiry:          clock.Now().Add(-time.Minute),
+		Retransmissions: 2,
+	}))
+	require.NoError(store.Put(&ARQEntry{
+		MessageID: [cConstants.MessageIDLength]byte{2},
+		Recipient: "bob",
+		Provider:  "acme",
+		Payload:   []byte("pending"),
+		Expiry:    clock.Now().Add(time.Minute),
+	}))
+
+	// An overdue message is retransmitted at once, the others when
+	// they expire.
+	require.NoError(s.restoreARQ())
+	require.Equal(1, s.retransmitsPending())
+	msg := s.popRetransmit()
+	require.Equal(&[cConstants.MessageIDLength]byte{1}, msg.ID)
+	require.Equal("bob", msg.Recipient)
+	require.Equal("acme", msg.Provider)
+	require.Equal([]byte("overdue"), msg.Payload)
+	require.Equal(uint32(2), msg.Retransmissions)
+	require.True(msg.Reliable)
+	require.True(msg.WithSURB)
+
+	clock.Advance(time.Minute)
+	require.Eventually(func() bool {
+		return s.retransmitsPending() == 1
+	}, time.Second, time.Millisecond)
+	msg = s.popRetransmit()
+	require.Equal(&[cConstants.MessageIDLength]byte{2}, msg.ID)
+	require.Equal([]byte("pending"), msg.Payload)
+}
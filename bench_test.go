@@ -0,0 +1,120 @@
+// bench_test.go - Session benchmarks.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	cConstants "github.com/katzenpost/client/constants"
+	"github.com/katzenpost/core/constants"
+	"github.com/katzenpost/core/log"
+	sConstants "github.com/katzenpost/core/sphinx/constants"
+)
+
+// The benchmarks report their throughput as msgs/s, and as MB/s where
+// the payload size is meaningful, in the standard format read by
+// benchstat and other tools:
+//
+//	go test -run NONE -bench . -benchmem
+
+func benchSession(b *testing.B) *Session {
+	logBackend, err := log.New("", "ERROR", true)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return &Session{
+		log:   logBackend.GetLogger("bench"),
+		clock: timerClock(systemClock{}),
+	}
+}
+
+func reportMsgsPerSec(b *testing.B, start time.Time) {
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+}
+
+func BenchmarkComposeMessage(b *testing.B) {
+	s := benchSession(b)
+	message := make([]byte, constants.UserForwardPayloadLength-4)
+	b.SetBytes(int64(len(message)))
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.composeMessage("recipient", "provider", message, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportMsgsPerSec(b, start)
+}
+
+func BenchmarkEgressQueue(b *testing.B) {
+	q := NewQueue(0, RejectNewest, nil)
+	msg := &Message{}
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if err := q.Push(msg); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := q.Pop(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportMsgsPerSec(b, start)
+}
+
+func BenchmarkSURBMap(b *testing.B) {
+	m := newSURBMap(cConstants.MaxPendingReplies)
+	msg := &Message{}
+	now := time.Now()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		var surbID [sConstants.SURBIDLength]byte
+		binary.BigEndian.PutUint64(surbID[:], uint64(i))
+		if err := m.Store(surbID, msg); err != nil {
+			b.Fatal(err)
+		}
+		if _, ok := m.LoadAndDelete(surbID, now); !ok {
+			b.Fatal("stored SURB ID not found")
+		}
+	}
+	reportMsgsPerSec(b, start)
+}
+
+func BenchmarkDedupFilter(b *testing.B) {
+	f := newDedupFilter(time.Minute)
+	message := make([]byte, constants.UserForwardPayloadLength-4)
+	id := new([cConstants.MessageIDLength]byte)
+	now := time.Now()
+	b.SetBytes(int64(len(message)))
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		binary.BigEndian.PutUint64(message, uint64(i))
+		if _, ok := f.Lookup(now, "recipient", "provider", message); ok {
+			b.Fatal("unexpected duplicate")
+		}
+		f.Add(now, "recipient", "provider", message, id)
+		if i%1024 == 1023 {
+			// keep the filter at a realistic size
+			f = newDedupFilter(time.Minute)
+		}
+	}
+	reportMsgsPerSec(b, start)
+}
@@ -0,0 +1,117 @@
+// chaos.go - Fault injection for testing.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"errors"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/client/config"
+	sConstants "github.com/katzenpost/core/sphinx/constants"
+)
+
+// ErrChaosProviderFailure is the error of a send failed by Debug.Chaos.
+var ErrChaosProviderFailure = errors.New("chaos: simulated Provider failure")
+
+// chaos injects the faults configured by Debug.Chaos.  A nil chaos
+// injects none.
+type chaos struct {
+	sync.Mutex
+
+	cfg *config.Chaos
+	rng *mrand.Rand
+}
+
+func newChaos(cfg *config.Chaos) *chaos {
+	if cfg == nil {
+		return nil
+	}
+	return &chaos{
+		cfg: cfg,
+		rng: mrand.New(mrand.NewSource(cfg.Seed)),
+	}
+}
+
+func (c *chaos) float64() float64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.rng.Float64()
+}
+
+// sendFault returns true if a send should be silently dropped, or an
+// error if it should fail.
+func (c *chaos) sendFault() (bool, error) {
+	if c == nil {
+		return false, nil
+	}
+	r := c.float64()
+	switch {
+	case r < c.cfg.DropRate:
+		return true, nil
+	case r < c.cfg.DropRate+c.cfg.ProviderErrorRate:
+		return false, ErrChaosProviderFailure
+	}
+	return false, nil
+}
+
+// ackDelay returns how long to delay a SURB reply.
+func (c *chaos) ackDelay() time.Duration {
+	if c == nil || c.cfg.MaxACKDelay == 0 || c.float64() >= c.cfg.ACKDelayRate {
+		return 0
+	}
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.rng.Int63n(int64(c.cfg.MaxACKDelay))+1) * time.Millisecond
+}
+
+// corrupt returns b with a random byte flipped, or b unchanged.
+func (c *chaos) corrupt(b []byte) []byte {
+	if c == nil || len(b) == 0 || c.float64() >= c.cfg.CorruptRate {
+		return b
+	}
+	c.Lock()
+	defer c.Unlock()
+	corrupted := append([]byte{}, b...)
+	corrupted[c.rng.Intn(len(corrupted))] ^= byte(1 + c.rng.Intn(255))
+	return corrupted
+}
+
+// sendCiphertext sends a message with a SURB, subject to Debug.Chaos.  A
+// dropped message appears to have been sent, with no path delay.
+func (s *Session) sendCiphertext(msg *Message, surbID *[sConstants.SURBIDLength]byte) ([]byte, time.Duration, error) {
+	if dropped, err := s.chaos.sendFault(); dropped || err != nil {
+		if dropped {
+			s.log.Debugf("chaos: dropping message %x", *msg.ID)
+		}
+		return nil, 0, err
+	}
+	return s.minclient.SendCiphertext(msg.Recipient, msg.Provider, surbID, msg.Payload)
+}
+
+// sendUnreliableCiphertext sends a message without a SURB, subject to
+// Debug.Chaos.
+func (s *Session) sendUnreliableCiphertext(msg *Message) error {
+	if dropped, err := s.chaos.sendFault(); dropped || err != nil {
+		if dropped {
+			s.log.Debugf("chaos: dropping message %x", *msg.ID)
+		}
+		return err
+	}
+	return s.minclient.SendUnreliableCiphertext(msg.Recipient, msg.Provider, msg.Payload)
+}
@@ -0,0 +1,93 @@
+// chaos_test.go - Fault injection tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/client/config"
+	sConstants "github.com/katzenpost/core/sphinx/constants"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChaos(t *testing.T) {
+	assert := assert.New(t)
+
+	var none *chaos
+	dropped, err := none.sendFault()
+	assert.False(dropped)
+	assert.NoError(err)
+	assert.Zero(none.ackDelay())
+	b := []byte("hello")
+	assert.Equal(b, none.corrupt(b))
+
+	c := newChaos(&config.Chaos{DropRate: 1})
+	dropped, err = c.sendFault()
+	assert.True(dropped)
+	assert.NoError(err)
+
+	c = newChaos(&config.Chaos{ProviderErrorRate: 1})
+	dropped, err = c.sendFault()
+	assert.False(dropped)
+	assert.Equal(ErrChaosProviderFailure, err)
+
+	c = newChaos(&config.Chaos{ACKDelayRate: 1, MaxACKDelay: 10, CorruptRate: 1})
+	delay := c.ackDelay()
+	assert.True(delay > 0 && delay <= 10*time.Millisecond)
+	corrupted := c.corrupt(b)
+	assert.Equal([]byte("hello"), b)
+	assert.NotEqual(b, corrupted)
+	assert.Len(corrupted, len(b))
+
+	// The same seed yields the same fault schedule.
+	cfg := &config.Chaos{DropRate: 0.5, Seed: 42}
+	c1, c2 := newChaos(cfg), newChaos(cfg)
+	for i := 0; i < 32; i++ {
+		d1, _ := c1.sendFault()
+		d2, _ := c2.sendFault()
+		assert.Equal(d1, d2)
+	}
+}
+
+func TestChaosACKDelay(t *testing.T) {
+	require := require.New(t)
+
+	clock := NewManualClock(epoch0)
+	s := testSession(t, clock, new(fakeMinclient))
+	s.chaos = newChaos(&config.Chaos{ACKDelayRate: 1, MaxACKDelay: 10})
+	received := func() uint64 {
+		return atomic.LoadUint64(&s.receivedCount)
+	}
+
+	// A delayed reply is handled once the session clock reaches it.
+	surbID := [sConstants.SURBIDLength]byte{1}
+	require.NoError(s.onACK(&surbID, []byte("reply")))
+	require.Zero(received())
+	clock.Advance(10 * time.Millisecond)
+	require.Eventually(func() bool {
+		return received() == 1
+	}, time.Second, time.Millisecond)
+
+	// A reply still delayed when the session halts is dropped.
+	require.NoError(s.onACK(&surbID, []byte("reply")))
+	haltTestSession(s)
+	clock.Advance(10 * time.Millisecond)
+	require.Equal(uint64(1), received())
+}
@@ -0,0 +1,113 @@
+// check.go - mixnet client configuration checks
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/katzenpost/client/config"
+	"github.com/katzenpost/core/log"
+	"github.com/katzenpost/core/pki"
+)
+
+// CheckConfig checks cfg more deeply than config.LoadFile does, by
+// fetching the current PKI document through the configured authority and
+// upstream proxy and checking the configuration against it, and by
+// checking that the configured log and error report directories exist.
+// It returns every problem found, each describing how to fix it, or nil
+// if none were found.
+func CheckConfig(ctx context.Context, cfg *config.Config) []error {
+	if err := cfg.FixupAndMinimallyValidate(); err != nil {
+		return []error{err}
+	}
+
+	problems := []error{}
+	if !cfg.Logging.Disable && cfg.Logging.File != "" {
+		if err := checkDirectory(cfg.Logging.File); err != nil {
+			problems = append(problems, fmt.Errorf("Logging: File: %v", err))
+		}
+	}
+	if cfg.Logging.ErrorReportFile != "" {
+		if err := checkDirectory(cfg.Logging.ErrorReportFile); err != nil {
+			problems = append(problems, fmt.Errorf("Logging: ErrorReportFile: %v", err))
+		}
+	}
+	if cfg.Logging.EventLogFile != "" {
+		if err := checkDirectory(cfg.Logging.EventLogFile); err != nil {
+			problems = append(problems, fmt.Errorf("Logging: EventLogFile: %v", err))
+		}
+	}
+
+	backendLog, err := log.New("", "ERROR", true)
+	if err != nil {
+		return append(problems, err)
+	}
+	doc, err := fetchDocument(ctx, cfg, backendLog)
+	if err != nil {
+		return append(problems, fmt.Errorf("failed to fetch the PKI document, check the authority address and key and the UpstreamProxy section: %v", err))
+	}
+	problems = append(problems, checkConfigAgainstDocument(cfg, doc)...)
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+// checkDirectory checks that the directory which would hold the file
+// exists.
+func checkDirectory(file string) error {
+	dir := filepath.Dir(file)
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("directory %v can not be used: %v", dir, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%v is not a directory", dir)
+	}
+	return nil
+}
+
+func checkConfigAgainstDocument(cfg *config.Config, doc *pki.Document) []error {
+	providers := make(map[string]*pki.MixDescriptor)
+	for _, desc := range doc.Providers {
+		providers[desc.Name] = desc
+	}
+
+	problems := []error{}
+	if cfg.Account != nil {
+		desc, ok := providers[cfg.Account.Provider]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Errorf("Account: Provider %v is not in the PKI document for epoch %v", cfg.Account.Provider, doc.Epoch))
+		case cfg.Account.ProviderKeyPin != nil && !bytes.Equal(cfg.Account.ProviderKeyPin.Bytes(), desc.IdentityKey.Bytes()):
+			problems = append(problems, fmt.Errorf("Account: ProviderKeyPin does not match the identity key of Provider %v", desc.Name))
+		}
+	}
+	for _, policy := range cfg.SendPolicy {
+		if policy.Provider == config.PolicyWildcard {
+			continue
+		}
+		if _, ok := providers[policy.Provider]; !ok {
+			problems = append(problems, fmt.Errorf("SendPolicy: Provider %v is not in the PKI document for epoch %v", policy.Provider, doc.Epoch))
+		}
+	}
+	return problems
+}
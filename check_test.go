@@ -0,0 +1,80 @@
+// check_test.go - mixnet client configuration check tests
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/katzenpost/client/config"
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/pki"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckConfigAgainstDocument(t *testing.T) {
+	require := require.New(t)
+
+	acmeKey, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	otherKey, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	doc := &pki.Document{
+		Epoch: 1,
+		Providers: []*pki.MixDescriptor{
+			{Name: "acme", IdentityKey: acmeKey.PublicKey()},
+		},
+	}
+	cfg := &config.Config{
+		Account: &config.Account{User: "alice", Provider: "acme", ProviderKeyPin: acmeKey.PublicKey()},
+		SendPolicy: []*config.SendPolicy{
+			{Recipient: "echo", Provider: "acme"},
+			{Recipient: "*", Provider: config.PolicyWildcard},
+		},
+	}
+	require.Empty(checkConfigAgainstDocument(cfg, doc))
+
+	// Every problem is reported.
+	cfg.Account.ProviderKeyPin = otherKey.PublicKey()
+	cfg.SendPolicy = append(cfg.SendPolicy, &config.SendPolicy{Recipient: "echo", Provider: "example"})
+	problems := checkConfigAgainstDocument(cfg, doc)
+	require.Len(problems, 2)
+	require.Contains(problems[0].Error(), "ProviderKeyPin")
+	require.Contains(problems[1].Error(), "example")
+
+	cfg.Account.Provider = "example"
+	problems = checkConfigAgainstDocument(cfg, doc)
+	require.Len(problems, 2)
+	require.Contains(problems[0].Error(), "Account: Provider example")
+}
+
+func TestCheckDirectory(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "check")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	require.NoError(checkDirectory(filepath.Join(dir, "client.log")))
+	require.Error(checkDirectory(filepath.Join(dir, "missing", "client.log")))
+
+	file := filepath.Join(dir, "file")
+	require.NoError(ioutil.WriteFile(file, nil, 0600))
+	require.Error(checkDirectory(filepath.Join(file, "client.log")))
+}
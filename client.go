@@ -22,6 +22,7 @@ import (
 	"errors"
 	"fmt"
 	mrand "math/rand"
+	"net/http"
 	"net/url"
 	"path/filepath"
 	"strings"
@@ -29,6 +30,9 @@ import (
 	"time"
 
 	"github.com/katzenpost/client/config"
+	"github.com/katzenpost/client/internal/errreport"
+	"github.com/katzenpost/client/internal/eventlog"
+	"github.com/katzenpost/client/internal/pkiclient"
 	"github.com/katzenpost/core/crypto/ecdh"
 	"github.com/katzenpost/core/crypto/rand"
 	"github.com/katzenpost/core/epochtime"
@@ -42,22 +46,21 @@ const (
 	initialPKIConsensusTimeout = 45 * time.Second
 )
 
+// ErrNoSession is the error issued when an operation requires a session
+// that has not been established.
+var ErrNoSession = errors.New("no session established")
+
+// ErrUnknownAccount is the error issued when no account has the given
+// link key fingerprint.
+var ErrUnknownAccount = errors.New("no account with that link key fingerprint")
+
+// ErrRegistrationProxy is the error issued when the Registration Options
+// ask for a SOCKS proxy which is not the upstream proxy.
+var ErrRegistrationProxy = errors.New("registration Options proxy does not match the UpstreamProxy")
+
 func AutoRegisterRandomClient(cfg *config.Config) (*config.Config, *ecdh.PrivateKey, error) {
 	// Retrieve a copy of the PKI consensus document.
-	logFilePath := ""
-	backendLog, err := log.New(logFilePath, "DEBUG", false)
-	if err != nil {
-		return nil, nil, err
-	}
-	proxyCfg := cfg.UpstreamProxyConfig()
-	pkiClient, err := cfg.NewPKIClient(backendLog, proxyCfg)
-	if err != nil {
-		return nil, nil, err
-	}
-	currentEpoch, _, _ := epochtime.FromUnix(time.Now().Unix())
-	ctx, cancel := context.WithTimeout(context.Background(), initialPKIConsensusTimeout)
-	defer cancel()
-	doc, _, err := pkiClient.Get(ctx, currentEpoch)
+	doc, err := fetchCurrentDocument(cfg)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -77,10 +80,50 @@ func AutoRegisterRandomClient(cfg *config.Config) (*config.Config, *ecdh.Private
 
 	// Register with that Provider.
 	fmt.Println("registering client with mixnet Provider")
-	linkKey, err := ecdh.NewKeypair(rand.Reader)
+	linkKey, err := registerWithProvider(cfg, registrationProvider)
 	if err != nil {
 		return nil, nil, err
 	}
+	return cfg, linkKey, nil
+}
+
+// fetchCurrentDocument retrieves the PKI consensus document for the
+// current epoch using the authority specified in cfg.
+func fetchCurrentDocument(cfg *config.Config) (*pki.Document, error) {
+	logFilePath := ""
+	backendLog, err := log.New(logFilePath, "DEBUG", false)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), initialPKIConsensusTimeout)
+	defer cancel()
+	return fetchDocument(ctx, cfg, backendLog)
+}
+
+// fetchDocument retrieves the PKI consensus document for the current
+// epoch using the authority and upstream proxy specified in cfg.
+func fetchDocument(ctx context.Context, cfg *config.Config, backendLog *log.Backend) (*pki.Document, error) {
+	proxyCfg := cfg.UpstreamProxyConfig()
+	pkiClient, err := cfg.NewPKIClient(backendLog, proxyCfg)
+	if err != nil {
+		return nil, err
+	}
+	currentEpoch, _, _ := epochtime.FromUnix(time.Now().Unix())
+	doc, _, err := pkiClient.Get(ctx, currentEpoch)
+	return doc, err
+}
+
+// registerWithProvider generates a new link key, registers a new account
+// for it with registrationProvider and sets the Account and Registration
+// sections of cfg accordingly.
+func registerWithProvider(cfg *config.Config, registrationProvider *pki.MixDescriptor) (*ecdh.PrivateKey, error) {
+	if len(registrationProvider.RegistrationHTTPAddresses) == 0 {
+		return nil, fmt.Errorf("Provider %v does not allow registration", registrationProvider.Name)
+	}
+	linkKey, err := ecdh.NewKeypair(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
 	account := &config.Account{
 		User:           fmt.Sprintf("%x", linkKey.PublicKey().Bytes()),
 		Provider:       registrationProvider.Name,
@@ -89,7 +132,7 @@ func AutoRegisterRandomClient(cfg *config.Config) (*config.Config, *ecdh.Private
 
 	// try to pick a registration address using a prefered transport
 	var addr string
-	loop0:
+loop0:
 	for _, t := range cfg.Debug.PreferedTransports {
 		for _, v := range registrationProvider.RegistrationHTTPAddresses {
 			if u, err := url.Parse(v); err == nil {
@@ -107,28 +150,67 @@ func AutoRegisterRandomClient(cfg *config.Config) (*config.Config, *ecdh.Private
 
 	u, err := url.Parse(addr)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	cfgRegistration := &config.Registration{
 		Address: u.Host,
-		Options: &registration.Options{
-			Scheme:       u.Scheme,
-			UseSocks:     strings.HasPrefix(cfg.UpstreamProxy.Type, "socks"),
-			SocksNetwork: cfg.UpstreamProxy.Network,
-			SocksAddress: cfg.UpstreamProxy.Address,
-		},
+		Options: registrationOptions(cfg, u.Scheme),
 	}
 	cfg.Account = account
 	cfg.Registration = cfgRegistration
 	err = RegisterClient(cfg, linkKey.PublicKey())
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	return cfg, linkKey, nil
+	return linkKey, nil
+}
+
+// registrationOptions returns the registration client options for the
+// URL scheme, dialing through the upstream proxy if one is configured.
+// A configuration which has not been validated has no upstream proxy.
+func registrationOptions(cfg *config.Config, scheme string) *registration.Options {
+	proxyCfg := cfg.UpstreamProxyConfig()
+	opts := &registration.Options{
+		Scheme: scheme,
+	}
+	if proxyCfg != nil && proxyCfg.IsSOCKS5() {
+		opts.UseSocks = true
+		opts.SocksNetwork = proxyCfg.Network
+		opts.SocksAddress = proxyCfg.Address
+	}
+	return opts
 }
 
+// checkRegistrationProxy returns ErrRegistrationProxy if the requested
+// Options ask for a SOCKS proxy other than the one opts dial through.
+func checkRegistrationProxy(requested, opts *registration.Options) error {
+	if requested == nil || !requested.UseSocks {
+		return nil
+	}
+	switch {
+	case !opts.UseSocks:
+		return ErrRegistrationProxy
+	case requested.SocksNetwork != "" && requested.SocksNetwork != opts.SocksNetwork:
+		return ErrRegistrationProxy
+	case requested.SocksAddress != "" && requested.SocksAddress != opts.SocksAddress:
+		return ErrRegistrationProxy
+	}
+	return nil
+}
+
+// RegisterClient registers the Account's link key with the Registration
+// address.  The upstream proxy is always used, and ErrRegistrationProxy
+// is returned if the Registration Options ask for a different one.
 func RegisterClient(cfg *config.Config, linkKey *ecdh.PublicKey) error {
-	client, err := registration.New(cfg.Registration.Address, cfg.Registration.Options)
+	scheme := "http"
+	if cfg.Registration.Options != nil && cfg.Registration.Options.Scheme != "" {
+		scheme = cfg.Registration.Options.Scheme
+	}
+	opts := registrationOptions(cfg, scheme)
+	if err := checkRegistrationProxy(cfg.Registration.Options, opts); err != nil {
+		return err
+	}
+	client, err := registration.New(cfg.Registration.Address, opts)
 	if err != nil {
 		return err
 	}
@@ -141,11 +223,22 @@ type Client struct {
 	cfg        *config.Config
 	logBackend *log.Backend
 	log        *logging.Logger
+	errReport  *errreport.Reporter
+	eventLog   *eventlog.Log
 	fatalErrCh chan error
 	haltedCh   chan interface{}
 	haltOnce   *sync.Once
 
-	session *Session
+	healthServer *http.Server
+
+	sessionLock sync.Mutex
+	session     *Session
+
+	pkiLock   sync.Mutex
+	pkiClient *pkiclient.Client
+
+	ephemeralLock sync.Mutex
+	ephemeral     map[*Session]*time.Timer
 }
 
 func (c *Client) Provider() string {
@@ -177,57 +270,256 @@ func (c *Client) GetLogger(name string) *logging.Logger {
 	return c.logBackend.GetLogger(name)
 }
 
+// ReportPanic records a recovered panic in the error report, if one is
+// configured, for example:
+//
+//	defer func() {
+//		if r := recover(); r != nil {
+//			c.ReportPanic(r)
+//			panic(r)
+//		}
+//	}()
+func (c *Client) ReportPanic(r interface{}) {
+	c.reportError(fmt.Errorf("panic: %v", r))
+	if c.errReport != nil {
+		c.errReport.Flush()
+	}
+}
+
+func (c *Client) reportError(err error) {
+	if c.errReport == nil {
+		return
+	}
+	if err := c.errReport.Record(err); err != nil {
+		c.log.Errorf("Failed to write error report: %v", err)
+	}
+}
+
 // Shutdown cleanly shuts down a given Client instance.
 func (c *Client) Shutdown() {
+	c.mustBeInitialized()
 	c.haltOnce.Do(func() { c.halt() })
 }
 
 // Wait waits till the Client is terminated for any reason.
 func (c *Client) Wait() {
+	c.mustBeInitialized()
 	<-c.haltedCh
 }
 
 func (c *Client) halt() {
 	c.log.Noticef("Starting graceful shutdown.")
-	if c.session != nil {
-		c.session.Shutdown()
+	c.recordEvent("stop", "client shutting down")
+	if s := c.getSession(); s != nil {
+		s.Shutdown()
+	}
+	c.destroyAllEphemeral()
+	if c.healthServer != nil {
+		c.healthServer.Close()
+	}
+	c.pkiLock.Lock()
+	if c.pkiClient != nil {
+		c.pkiClient.Halt()
+	}
+	c.pkiLock.Unlock()
+	if c.errReport != nil {
+		if err := c.errReport.Flush(); err != nil {
+			c.log.Errorf("Failed to write error report: %v", err)
+		}
 	}
 	close(c.fatalErrCh)
 	close(c.haltedCh)
 }
 
-// NewSession creates and returns a new session or an error.
-func (c *Client) NewSession(linkKey *ecdh.PrivateKey) (*Session, error) {
+// QueueStats returns the egress queue statistics of the account with the
+// given link key fingerprint, as listed by Accounts, or of the Client's
+// own account if account is empty.
+func (c *Client) QueueStats(account string) (*QueueStats, error) {
+	s, err := c.accountSession(account)
+	if err != nil {
+		return nil, err
+	}
+	return s.QueueStats(), nil
+}
+
+// AllQueueStats returns the egress queue statistics of every account,
+// keyed by link key fingerprint.
+func (c *Client) AllQueueStats() map[string]*QueueStats {
+	all := make(map[string]*QueueStats)
+	for _, s := range c.sessions() {
+		all[s.Info().LinkKeyFingerprint] = s.QueueStats()
+	}
+	return all
+}
+
+// sessions returns the session of every account, the Client's own
+// account first.
+func (c *Client) sessions() []*Session {
+	sessions := []*Session{}
+	if s := c.getSession(); s != nil {
+		sessions = append(sessions, s)
+	}
+	return append(sessions, c.ephemeralSessions()...)
+}
+
+// accountSession returns the session of the account with the given link
+// key fingerprint, or of the Client's own account if account is empty.
+func (c *Client) accountSession(account string) (*Session, error) {
+	if account == "" {
+		if s := c.getSession(); s != nil {
+			return s, nil
+		}
+		return nil, ErrNoSession
+	}
+	for _, s := range c.sessions() {
+		if s.Info().LinkKeyFingerprint == account {
+			return s, nil
+		}
+	}
+	return nil, ErrUnknownAccount
+}
+
+// CheckMail forces an immediate fetch for every account of the Client,
+// as Session.CheckMail does, returning the total number of messages
+// received.  It fails only if no account could fetch.
+func (c *Client) CheckMail(ctx context.Context) (int, error) {
+	sessions := c.sessions()
+	if len(sessions) == 0 {
+		return 0, ErrNoSession
+	}
+
+	type result struct {
+		n   int
+		err error
+	}
+	resultCh := make(chan result, len(sessions))
+	for _, s := range sessions {
+		go func(s *Session) {
+			n, err := s.CheckMail(ctx)
+			resultCh <- result{n, err}
+		}(s)
+	}
+	// An error is only returned if no session fetched, so that one
+	// session's cooldown does not hide the others' results.
+	total := 0
+	fetched := false
 	var err error
+	for range sessions {
+		r := <-resultCh
+		total += r.n
+		if r.err == nil {
+			fetched = true
+		} else if err == nil {
+			err = r.err
+		}
+	}
+	if fetched {
+		return total, nil
+	}
+	return total, err
+}
+
+// sharedPKIClient returns the caching PKI client shared by all of the
+// Client's sessions, so that each document is only fetched once.
+func (c *Client) sharedPKIClient() (*pkiclient.Client, error) {
+	c.pkiLock.Lock()
+	defer c.pkiLock.Unlock()
+	if c.pkiClient == nil {
+		impl, err := c.cfg.NewPKIClient(c.logBackend, c.cfg.UpstreamProxyConfig())
+		if err != nil {
+			return nil, err
+		}
+		c.pkiClient = pkiclient.New(impl)
+	}
+	return c.pkiClient, nil
+}
+
+// NewSession creates and returns a new session or an error.  If linkKey
+// is nil, the link key is derived from the Account's SeedFile.
+func (c *Client) NewSession(linkKey *ecdh.PrivateKey, opts ...SessionOption) (*Session, error) {
+	c.mustBeInitialized()
+	if linkKey == nil {
+		var err error
+		if linkKey, err = c.cfg.Account.LinkKey(); err != nil {
+			return nil, err
+		}
+	}
+	pkiClient, err := c.sharedPKIClient()
+	if err != nil {
+		return nil, err
+	}
 	timeout := time.Duration(c.cfg.Debug.SessionDialTimeout) * time.Second
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	c.session, err = NewSession(ctx, c.fatalErrCh, c.logBackend, c.cfg, linkKey)
-	return c.session, err
+	opts = append([]SessionOption{WithPKIClient(pkiClient)}, opts...)
+	if c.eventLog != nil {
+		opts = append(opts, withEventLog(c.eventLog))
+	}
+	s, err := NewSession(ctx, c.fatalErrCh, c.logBackend, c.cfg, linkKey, opts...)
+	c.sessionLock.Lock()
+	c.session = s
+	c.sessionLock.Unlock()
+	return s, err
+}
+
+// getSession returns the session of the Client's own account, which is
+// read concurrently by the health endpoints.
+func (c *Client) getSession() *Session {
+	c.sessionLock.Lock()
+	defer c.sessionLock.Unlock()
+	return c.session
 }
 
 // New creates a new Client with the provided configuration.
 func New(cfg *config.Config) (*Client, error) {
+	if err := checkConfig(cfg); err != nil {
+		return nil, err
+	}
 	c := new(Client)
 	c.cfg = cfg
 	c.fatalErrCh = make(chan error)
 	c.haltedCh = make(chan interface{})
 	c.haltOnce = new(sync.Once)
+	c.ephemeral = make(map[*Session]*time.Timer)
 
 	if err := c.initLogging(); err != nil {
 		return nil, err
 	}
+	if c.cfg.Logging.ErrorReportFile != "" {
+		c.errReport = errreport.New(c.cfg.Logging.ErrorReportFile)
+	}
+	if c.cfg.Logging.EventLogFile != "" {
+		var err error
+		c.eventLog, err = eventlog.Open(c.cfg.Logging.EventLogFile, eventLogEntries)
+		if err != nil {
+			return nil, err
+		}
+		c.recordEvent("start", "client started")
+	}
 
 	c.log.Noticef("😼 Katzenpost is still pre-alpha.  DO NOT DEPEND ON IT FOR STRONG SECURITY OR ANONYMITY. 😼")
 
-	// Start the fatal error watcher.
+	if c.cfg.Health != nil {
+		if err := c.startHealthServer(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Start the fatal error watcher.  Recoverable errors are handled by
+	// the Session's supervisor, and are only recorded here.
 	go func() {
-		err, ok := <-c.fatalErrCh
-		if !ok {
+		for err := range c.fatalErrCh {
+			c.reportError(err)
+			if IsRecoverable(err) {
+				c.log.Warningf("Recoverable error: %v", err)
+				c.recordEvent("error", "%v", err)
+				continue
+			}
+			c.log.Warningf("Shutting down due to error: %v", err)
+			c.recordEvent("fatal", "%v", err)
+			c.Shutdown()
 			return
 		}
-		c.log.Warningf("Shutting down due to error: %v", err)
-		c.Shutdown()
 	}()
 	return c, nil
 }
@@ -0,0 +1,199 @@
+// clock.go - Session clock and timers.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Timer is a single event timer created by a TimerClock, with the same
+// semantics as time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is delivered.
+	C() <-chan time.Time
+
+	// Stop prevents the Timer from firing.
+	Stop() bool
+
+	// Reset changes the timer to expire after duration d.
+	Reset(d time.Duration) bool
+}
+
+// TimerClock is a Clock which also creates the timers that drive the
+// Session's Poisson send schedule, retransmissions and housekeeping.
+// A Session whose Clock is not a TimerClock uses real timers.
+type TimerClock interface {
+	Clock
+
+	// NewTimer creates a Timer which fires after duration d.
+	NewTimer(d time.Duration) Timer
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+// realTimerClock adds real timers to a Clock.
+type realTimerClock struct {
+	Clock
+}
+
+func (realTimerClock) NewTimer(d time.Duration) Timer {
+	return systemClock{}.NewTimer(d)
+}
+
+// timerClock returns clock as a TimerClock, using real timers if it does
+// not create its own.
+func timerClock(clock Clock) TimerClock {
+	if tc, ok := clock.(TimerClock); ok {
+		return tc
+	}
+	return realTimerClock{clock}
+}
+
+// ManualClock is a TimerClock whose time only moves when Advance or Step
+// is called, so that tests can step a Session through virtual time.
+// Together with WithScheduleRand it makes the send schedule exactly
+// reproducible.
+type ManualClock struct {
+	sync.Mutex
+
+	now    time.Time
+	timers []*manualTimer
+}
+
+// NewManualClock returns a ManualClock set to now.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now returns the clock's current time.
+func (c *ManualClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+// NewTimer creates a Timer which fires once the clock has been advanced
+// by duration d.
+func (c *ManualClock) NewTimer(d time.Duration) Timer {
+	t := &manualTimer{
+		clock: c,
+		ch:    make(chan time.Time, 1),
+	}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers that expire
+// on the way in order of their deadlines.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.Lock()
+	end := c.now.Add(d)
+	for {
+		sort.Slice(c.timers, func(i, j int) bool {
+			return c.timers[i].deadline.Before(c.timers[j].deadline)
+		})
+		if len(c.timers) == 0 || c.timers[0].deadline.After(end) {
+			break
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.deadline
+		select {
+		case t.ch <- c.now:
+		default:
+		}
+	}
+	c.now = end
+	c.Unlock()
+}
+
+// Step advances the clock to the deadline of the next pending timer and
+// fires only that timer, so that a test can observe the Session's
+// response to each event in turn.  It returns false if no timer is
+// pending.
+func (c *ManualClock) Step() bool {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.timers) == 0 {
+		return false
+	}
+	sort.Slice(c.timers, func(i, j int) bool {
+		return c.timers[i].deadline.Before(c.timers[j].deadline)
+	})
+	t := c.timers[0]
+	c.timers = c.timers[1:]
+	if t.deadline.After(c.now) {
+		c.now = t.deadline
+	}
+	select {
+	case t.ch <- c.now:
+	default:
+	}
+	return true
+}
+
+// remove stops t, returning true if it was pending.
+func (c *ManualClock) remove(t *manualTimer) bool {
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type manualTimer struct {
+	clock    *ManualClock
+	ch       chan time.Time
+	deadline time.Time
+}
+
+func (t *manualTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *manualTimer) Stop() bool {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *manualTimer) Reset(d time.Duration) bool {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+	wasPending := t.clock.remove(t)
+	t.deadline = t.clock.now.Add(d)
+	if d > 0 && t.deadline.Before(t.clock.now) {
+		// d is effectively forever
+		t.deadline = time.Unix(1<<62, 0)
+	}
+	t.clock.timers = append(t.clock.timers, t)
+	return wasPending
+}
@@ -0,0 +1,107 @@
+// clock_test.go - Session clock and timer tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fired(t Timer) bool {
+	select {
+	case <-t.C():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestManualClock(t *testing.T) {
+	assert := assert.New(t)
+
+	start := time.Unix(1000, 0)
+	c := NewManualClock(start)
+	a := c.NewTimer(time.Second)
+	b := c.NewTimer(3 * time.Second)
+	stopped := c.NewTimer(2 * time.Second)
+	forever := c.NewTimer(time.Duration(1<<63 - 1))
+	assert.True(stopped.Stop())
+
+	c.Advance(999 * time.Millisecond)
+	assert.False(fired(a))
+	assert.Equal(start.Add(999*time.Millisecond), c.Now())
+
+	c.Advance(time.Millisecond)
+	assert.True(fired(a))
+	assert.False(fired(b))
+
+	// A reset timer fires relative to the time it was reset.
+	a.Reset(time.Second)
+	c.Advance(5 * time.Second)
+	assert.True(fired(a))
+	assert.True(fired(b))
+	assert.False(fired(stopped))
+	assert.False(fired(forever))
+	assert.Equal(start.Add(6*time.Second), c.Now())
+}
+
+func TestTimerQueueManualClock(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewManualClock(time.Unix(1000, 0))
+	q := new(Queue)
+	a := newTimerQueue(q, c)
+	defer a.Halt()
+
+	m := &Message{QueuePriority: uint64(c.Now().Add(time.Minute).UnixNano())}
+	a.Push(m)
+	<-time.After(10 * time.Millisecond)
+	assert.Equal(0, q.Len())
+
+	c.Advance(time.Minute)
+	for i := 0; i < 100 && q.Len() == 0; i++ {
+		<-time.After(time.Millisecond)
+	}
+	assert.Equal(1, q.Len())
+}
+
+func TestManualClockStep(t *testing.T) {
+	assert := assert.New(t)
+
+	start := time.Unix(1000, 0)
+	c := NewManualClock(start)
+	assert.False(c.Step())
+
+	a := c.NewTimer(2 * time.Second)
+	b := c.NewTimer(time.Second)
+	b2 := c.NewTimer(time.Second)
+
+	// Each step fires exactly one timer, even of several due together.
+	assert.True(c.Step())
+	assert.True(fired(b) != fired(b2))
+	assert.False(fired(a))
+	assert.Equal(start.Add(time.Second), c.Now())
+
+	assert.True(c.Step())
+	assert.Equal(start.Add(time.Second), c.Now())
+	assert.True(c.Step())
+	assert.True(fired(a))
+	assert.Equal(start.Add(2*time.Second), c.Now())
+	assert.False(c.Step())
+}
@@ -0,0 +1,101 @@
+// activity.go - Katzenpost client activity window configuration.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const minutesPerDay = 24 * 60
+
+// ActivityWindow limits the client's network activity, including decoy
+// traffic and fetching, to a daily window of local time.  Messages sent
+// outside of the window are queued until it opens.
+type ActivityWindow struct {
+	// Start and End are the local times the window opens and closes,
+	// as "HH:MM".  A window may span midnight.
+	Start string
+	End   string
+
+	// RampMinutes is the number of minutes after the window opens and
+	// before it closes over which traffic is linearly ramped up and down,
+	// instead of starting and stopping abruptly.
+	RampMinutes int
+
+	start int
+	end   int
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("'%v' is not a HH:MM time", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w *ActivityWindow) validate() error {
+	var err error
+	if w.start, err = parseClock(w.Start); err != nil {
+		return fmt.Errorf("Start %v", err)
+	}
+	if w.end, err = parseClock(w.End); err != nil {
+		return fmt.Errorf("End %v", err)
+	}
+	if w.start == w.end {
+		return errors.New("Start and End are equal")
+	}
+	if w.RampMinutes < 0 || 2*w.RampMinutes > w.length() {
+		return errors.New("RampMinutes must fit twice within the window")
+	}
+	return nil
+}
+
+// length returns the length of the window in minutes.
+func (w *ActivityWindow) length() int {
+	return (w.end - w.start + minutesPerDay) % minutesPerDay
+}
+
+// Factor returns the fraction of the normal traffic rate at which the
+// client is active at the given time: 0 outside of the window, 1 inside
+// it and in between while ramping up or down.
+func (w *ActivityWindow) Factor(now time.Time) float64 {
+	minute := float64(now.Hour()*60+now.Minute()) + float64(now.Second())/60
+	elapsed := minute - float64(w.start)
+	if elapsed < 0 {
+		elapsed += minutesPerDay
+	}
+	length := float64(w.length())
+	if elapsed >= length {
+		return 0
+	}
+	if w.RampMinutes == 0 {
+		return 1
+	}
+	ramp := float64(w.RampMinutes)
+	remaining := length - elapsed
+	factor := 1.0
+	if elapsed < ramp {
+		factor = elapsed / ramp
+	}
+	if remaining < ramp && remaining/ramp < factor {
+		factor = remaining / ramp
+	}
+	return factor
+}
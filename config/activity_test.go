@@ -0,0 +1,55 @@
+// activity_test.go - Katzenpost client activity window tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func at(hour, minute int) time.Time {
+	return time.Date(2020, 1, 1, hour, minute, 0, 0, time.Local)
+}
+
+func TestActivityWindow(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	w := &ActivityWindow{Start: "07:00", End: "23:00", RampMinutes: 30}
+	require.NoError(w.validate())
+	assert.Equal(0.0, w.Factor(at(6, 59)))
+	assert.Equal(0.0, w.Factor(at(7, 0)))
+	assert.Equal(0.5, w.Factor(at(7, 15)))
+	assert.Equal(1.0, w.Factor(at(12, 0)))
+	assert.Equal(0.5, w.Factor(at(22, 45)))
+	assert.Equal(0.0, w.Factor(at(23, 0)))
+	assert.Equal(0.0, w.Factor(at(3, 0)))
+
+	// A window spanning midnight.
+	w = &ActivityWindow{Start: "22:00", End: "02:00"}
+	require.NoError(w.validate())
+	assert.Equal(1.0, w.Factor(at(23, 30)))
+	assert.Equal(1.0, w.Factor(at(1, 0)))
+	assert.Equal(0.0, w.Factor(at(12, 0)))
+
+	assert.Error((&ActivityWindow{Start: "7am", End: "23:00"}).validate())
+	assert.Error((&ActivityWindow{Start: "07:00", End: "07:00"}).validate())
+	assert.Error((&ActivityWindow{Start: "07:00", End: "08:00", RampMinutes: 31}).validate())
+}
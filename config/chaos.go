@@ -0,0 +1,69 @@
+// chaos.go - Katzenpost client fault injection configuration.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Chaos injects faults into the Session, so that the retransmission and
+// error handling can be exercised without a misbehaving network.  It
+// must never be enabled outside of testing.  Each rate is the
+// probability, between 0 and 1, of the fault for each event.
+type Chaos struct {
+	// DropRate is the rate at which sent messages are silently dropped
+	// instead of being handed to the Provider.
+	DropRate float64
+
+	// ProviderErrorRate is the rate at which sending a message fails as
+	// if the Provider returned an error.
+	ProviderErrorRate float64
+
+	// ACKDelayRate is the rate at which SURB replies are delayed, by up
+	// to MaxACKDelay milliseconds.
+	ACKDelayRate float64
+	MaxACKDelay  int
+
+	// CorruptRate is the rate at which a byte of a fetched message or
+	// SURB reply is corrupted before it is handled.
+	CorruptRate float64
+
+	// Seed seeds the fault schedule, so that runs can be repeated.
+	Seed int64
+}
+
+func (c *Chaos) validate() error {
+	rates := map[string]float64{
+		"DropRate":          c.DropRate,
+		"ProviderErrorRate": c.ProviderErrorRate,
+		"ACKDelayRate":      c.ACKDelayRate,
+		"CorruptRate":       c.CorruptRate,
+	}
+	for name, rate := range rates {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%v must be between 0 and 1", name)
+		}
+	}
+	if c.DropRate+c.ProviderErrorRate > 1 {
+		return errors.New("DropRate and ProviderErrorRate must not add up to more than 1")
+	}
+	if c.MaxACKDelay < 0 {
+		return errors.New("MaxACKDelay must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+// chaos_test.go - Katzenpost client fault injection configuration tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChaosValidate(t *testing.T) {
+	require := require.New(t)
+
+	require.NoError((&Chaos{}).validate())
+	require.NoError((&Chaos{DropRate: 0.4, ProviderErrorRate: 0.6, MaxACKDelay: 100}).validate())
+	require.Error((&Chaos{CorruptRate: 1.5}).validate())
+	require.Error((&Chaos{ACKDelayRate: -0.1}).validate())
+	require.Error((&Chaos{DropRate: 0.6, ProviderErrorRate: 0.6}).validate())
+	require.Error((&Chaos{MaxACKDelay: -1}).validate())
+}
@@ -18,10 +18,13 @@
 package config
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/mail"
+	"path/filepath"
 	"strings"
 
 	"github.com/BurntSushi/toml"
@@ -29,9 +32,12 @@ import (
 	vClient "github.com/katzenpost/authority/voting/client"
 	vServerConfig "github.com/katzenpost/authority/voting/server/config"
 	"github.com/katzenpost/client/internal/proxy"
+	"github.com/katzenpost/client/seed"
+	"github.com/katzenpost/core/crypto/ecdh"
 	"github.com/katzenpost/core/crypto/eddsa"
 	"github.com/katzenpost/core/log"
 	"github.com/katzenpost/core/pki"
+	"github.com/katzenpost/core/utils"
 	registration "github.com/katzenpost/registration_client"
 	"golang.org/x/net/idna"
 	"golang.org/x/text/secure/precis"
@@ -42,6 +48,9 @@ const (
 	defaultPollingInterval             = 10
 	defaultInitialMaxPKIRetrievalDelay = 30
 	defaultSessionDialTimeout          = 30
+	defaultMaxDocumentAge              = 1
+	defaultRetransmitBackoff           = 2.0
+	defaultMaxRetransmitDelay          = 3600
 )
 
 var defaultLogging = Logging{
@@ -60,9 +69,26 @@ type Logging struct {
 
 	// Level specifies the log level.
 	Level string
+
+	// ErrorReportFile optionally specifies a file where a sanitized
+	// summary of critical errors is kept, for the user to submit with
+	// bug reports if they choose.  It is never transmitted.
+	ErrorReportFile string
+
+	// EventLogFile optionally specifies a file where a bounded log of
+	// session events, such as connection changes, new epochs and fatal
+	// errors, is kept for postmortem debugging.  It never contains
+	// message payloads.
+	EventLogFile string
 }
 
 func (lCfg *Logging) validate() error {
+	if lCfg.ErrorReportFile != "" && !filepath.IsAbs(lCfg.ErrorReportFile) {
+		return fmt.Errorf("config: Logging: ErrorReportFile '%v' must be an absolute path", lCfg.ErrorReportFile)
+	}
+	if lCfg.EventLogFile != "" && !filepath.IsAbs(lCfg.EventLogFile) {
+		return fmt.Errorf("config: Logging: EventLogFile '%v' must be an absolute path", lCfg.EventLogFile)
+	}
 	lvl := strings.ToUpper(lCfg.Level)
 	switch lvl {
 	case "ERROR", "WARNING", "NOTICE", "INFO", "DEBUG":
@@ -75,6 +101,37 @@ func (lCfg *Logging) validate() error {
 	return nil
 }
 
+// Health is the health endpoint configuration.
+type Health struct {
+	// Address is the loopback address and port on which /healthz,
+	// /readyz and /metrics are served.
+	Address string
+
+	// MaxDocumentAge is the number of epochs the PKI document may lag
+	// behind the current epoch while the client is considered ready.
+	MaxDocumentAge int
+}
+
+func (h *Health) fixup() {
+	if h.MaxDocumentAge == 0 {
+		h.MaxDocumentAge = defaultMaxDocumentAge
+	}
+}
+
+func (h *Health) validate() error {
+	host, _, err := net.SplitHostPort(h.Address)
+	if err != nil {
+		return fmt.Errorf("config: Health: Address '%v' is invalid: %v", h.Address, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("config: Health: Address '%v' is not a loopback address", h.Address)
+	}
+	if h.MaxDocumentAge < 0 {
+		return errors.New("config: Health: MaxDocumentAge must not be negative")
+	}
+	return nil
+}
+
 // Debug is the debug configuration.
 type Debug struct {
 	DisableDecoyTraffic bool
@@ -98,6 +155,35 @@ type Debug struct {
 	// transmit performance.
 	PollingInterval int
 
+	// MinPollingInterval and MaxPollingInterval, in milliseconds, enable
+	// adaptive polling when MaxPollingInterval is set.  The receive queue
+	// is then polled more often while messages are arriving or replies
+	// are expected, and less often when idle, within these bounds.
+	// MinPollingInterval defaults to PollingInterval.
+	MinPollingInterval int
+	MaxPollingInterval int
+
+	// EgressQueueSize is the maximum number of messages waiting to be
+	// sent.  By default this is constants.MaxEgressQueueSize.
+	EgressQueueSize int
+
+	// EvictOldestQueued makes a full egress queue drop its oldest
+	// message to accept a new one, instead of rejecting the new one.
+	EvictOldestQueued bool
+
+	// RetransmitBackoff is the factor by which the reply timeout of a
+	// reliable message grows with each retransmission.  By default this
+	// is 2.
+	RetransmitBackoff float64
+
+	// MaxRetransmitDelay caps the reply timeout of a retransmitted
+	// message, in seconds.  By default this is one hour.
+	MaxRetransmitDelay int
+
+	// Chaos optionally injects faults for testing.  It must never be
+	// set outside of testing.
+	Chaos *Chaos
+
 	// PreferedTransports is a list of the transports will be used to make
 	// outgoing network connections, with the most prefered first.
 	PreferedTransports []pki.Transport
@@ -113,6 +199,44 @@ func (d *Debug) fixup() {
 	if d.SessionDialTimeout == 0 {
 		d.SessionDialTimeout = defaultSessionDialTimeout
 	}
+	if d.MaxPollingInterval != 0 && d.MinPollingInterval == 0 {
+		d.MinPollingInterval = d.PollingInterval
+	}
+	if d.RetransmitBackoff == 0 {
+		d.RetransmitBackoff = defaultRetransmitBackoff
+	}
+	if d.MaxRetransmitDelay == 0 {
+		d.MaxRetransmitDelay = defaultMaxRetransmitDelay
+	}
+}
+
+func (d *Debug) validate() error {
+	if d.MinPollingInterval < 0 || d.MaxPollingInterval < 0 {
+		return errors.New("config: Debug: polling intervals must not be negative")
+	}
+	if d.MaxPollingInterval != 0 {
+		if d.MinPollingInterval == 0 {
+			return errors.New("config: Debug: MinPollingInterval must be set with MaxPollingInterval")
+		}
+		if d.MinPollingInterval > d.MaxPollingInterval {
+			return errors.New("config: Debug: MinPollingInterval exceeds MaxPollingInterval")
+		}
+	}
+	if d.EgressQueueSize < 0 {
+		return errors.New("config: Debug: EgressQueueSize must not be negative")
+	}
+	if d.RetransmitBackoff < 1 {
+		return errors.New("config: Debug: RetransmitBackoff must be at least 1")
+	}
+	if d.MaxRetransmitDelay < 0 {
+		return errors.New("config: Debug: MaxRetransmitDelay must not be negative")
+	}
+	if d.Chaos != nil {
+		if err := d.Chaos.validate(); err != nil {
+			return fmt.Errorf("config: Debug: Chaos: %v", err)
+		}
+	}
+	return nil
 }
 
 // NonvotingAuthority is a non-voting authority configuration.
@@ -224,6 +348,35 @@ type Account struct {
 
 	// ProviderKeyPin is the optional pinned provider signing key.
 	ProviderKeyPin *eddsa.PublicKey
+
+	// SeedFile optionally names a file holding the seed phrase of a
+	// master seed, from which the link key is derived instead of being
+	// passed to Client.NewSession.  It must be an absolute path that
+	// only its owner can read.
+	SeedFile string
+
+	// SeedIndex is the account index of the link key derived from the
+	// SeedFile.
+	SeedIndex uint32
+}
+
+// LinkKey returns the link key derived from the SeedFile, or nil if no
+// SeedFile is set.  The caller owns the key and should Reset it once it
+// is no longer needed.
+func (accCfg *Account) LinkKey() (*ecdh.PrivateKey, error) {
+	if accCfg.SeedFile == "" {
+		return nil, nil
+	}
+	phrase, err := resolveSecret("", accCfg.SeedFile, "")
+	if err != nil {
+		return nil, err
+	}
+	masterSeed, err := seed.Decode(phrase)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.ExplicitBzero(masterSeed)
+	return seed.LinkKey(masterSeed, accCfg.SeedIndex)
 }
 
 func (accCfg *Account) fixup(cfg *Config) error {
@@ -256,6 +409,9 @@ func (accCfg *Account) validate() error {
 	if accCfg.Provider == "" {
 		return errors.New("provider is missing")
 	}
+	if accCfg.SeedFile != "" && !filepath.IsAbs(accCfg.SeedFile) {
+		return fmt.Errorf("SeedFile '%v' must be an absolute path", accCfg.SeedFile)
+	}
 	return nil
 }
 
@@ -288,9 +444,21 @@ type UpstreamProxy struct {
 
 	// Password is the optional proxy password.
 	Password string
+
+	// PasswordFile and PasswordCommand optionally keep the Password out
+	// of the configuration file, by reading it from a file only its owner
+	// can read or from the output of a command, once when the
+	// configuration is loaded.
+	PasswordFile    string
+	PasswordCommand string
 }
 
 func (uCfg *UpstreamProxy) toProxyConfig() (*proxy.Config, error) {
+	password, err := resolveSecret(uCfg.Password, uCfg.PasswordFile, uCfg.PasswordCommand)
+	if err != nil {
+		return nil, fmt.Errorf("config: UpstreamProxy: Password: %v", err)
+	}
+
 	// This is kind of dumb, but this is the cleanest way I can think of
 	// doing this.
 	cfg := &proxy.Config{
@@ -298,7 +466,7 @@ func (uCfg *UpstreamProxy) toProxyConfig() (*proxy.Config, error) {
 		Network:  uCfg.Network,
 		Address:  uCfg.Address,
 		User:     uCfg.User,
-		Password: uCfg.Password,
+		Password: password,
 	}
 	if err := cfg.FixupAndValidate(); err != nil {
 		return nil, err
@@ -308,6 +476,10 @@ func (uCfg *UpstreamProxy) toProxyConfig() (*proxy.Config, error) {
 
 // Config is the top level client configuration.
 type Config struct {
+	// SchemaVersion is the version of the configuration layout, files
+	// using an older layout are migrated when loaded.
+	SchemaVersion int
+
 	Logging            *Logging
 	UpstreamProxy      *UpstreamProxy
 	Debug              *Debug
@@ -317,6 +489,9 @@ type Config struct {
 	Registration       *Registration
 	Panda              *Panda
 	Reunion            *Reunion
+	Health             *Health
+	ActivityWindow     *ActivityWindow
+	SendPolicy         []*SendPolicy
 	upstreamProxy      *proxy.Config
 }
 
@@ -329,28 +504,37 @@ func (c *Config) UpstreamProxyConfig() *proxy.Config {
 // FixupAndMinimallyValidate applies defaults to config entries and validates the
 // all but the Account and Registration configuration sections.
 func (c *Config) FixupAndMinimallyValidate() error {
+	switch {
+	case c.SchemaVersion == 0:
+		c.SchemaVersion = CurrentSchemaVersion
+	case c.SchemaVersion != CurrentSchemaVersion:
+		return fmt.Errorf("config: SchemaVersion %d is unsupported, the latest is %d", c.SchemaVersion, CurrentSchemaVersion)
+	}
+
 	// Handle missing sections if possible.
 	if c.Logging == nil {
 		c.Logging = &defaultLogging
 	}
 	if c.Debug == nil {
-		c.Debug = &Debug{
-			PollingInterval:             defaultPollingInterval,
-			InitialMaxPKIRetrievalDelay: defaultInitialMaxPKIRetrievalDelay,
-		}
-	} else {
-		c.Debug.fixup()
+		c.Debug = &Debug{}
 	}
+	c.Debug.fixup()
 
 	// Validate/fixup the various sections.
 	if err := c.Logging.validate(); err != nil {
 		return err
 	}
-	if uCfg, err := c.UpstreamProxy.toProxyConfig(); err == nil {
-		c.upstreamProxy = uCfg
-	} else {
+	if err := c.Debug.validate(); err != nil {
 		return err
 	}
+	// The proxy password is only resolved once, as it may run a command.
+	if c.upstreamProxy == nil {
+		uCfg, err := c.UpstreamProxy.toProxyConfig()
+		if err != nil {
+			return err
+		}
+		c.upstreamProxy = uCfg
+	}
 	switch {
 	case c.NonvotingAuthority == nil && c.VotingAuthority != nil:
 		if err := c.VotingAuthority.validate(); err != nil {
@@ -380,6 +564,27 @@ func (c *Config) FixupAndMinimallyValidate() error {
 		}
 	}
 
+	// ActivityWindow is optional
+	if c.ActivityWindow != nil {
+		if err := c.ActivityWindow.validate(); err != nil {
+			return fmt.Errorf("config: ActivityWindow is invalid: %v", err)
+		}
+	}
+
+	// Health is optional
+	if c.Health != nil {
+		c.Health.fixup()
+		if err := c.Health.validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range c.SendPolicy {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("config: SendPolicy '%v@%v' is invalid: %v", p.Recipient, p.Provider, err)
+		}
+	}
+
 	return nil
 }
 
@@ -415,8 +620,28 @@ func (c *Config) FixupAndValidate() error {
 // Load parses and validates the provided buffer b as a config file body and
 // returns the Config.
 func Load(b []byte) (*Config, error) {
+	// Bring older layouts up to date before decoding.  A layout which
+	// needed no changes is decoded as given, so that errors refer to the
+	// user's file.
+	tree := make(map[string]interface{})
+	if _, err := toml.Decode(string(b), &tree); err != nil {
+		return nil, err
+	}
+	migrated, err := migrate(tree)
+	if err != nil {
+		return nil, err
+	}
+	src := string(b)
+	if migrated {
+		buf := new(bytes.Buffer)
+		if err := toml.NewEncoder(buf).Encode(tree); err != nil {
+			return nil, err
+		}
+		src = buf.String()
+	}
+
 	cfg := new(Config)
-	md, err := toml.Decode(string(b), cfg)
+	md, err := toml.Decode(src, cfg)
 	if err != nil {
 		return nil, err
 	}
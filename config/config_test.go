@@ -0,0 +1,103 @@
+// config_test.go - Katzenpost client configuration tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/katzenpost/client/seed"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugPollingBounds(t *testing.T) {
+	require := require.New(t)
+
+	d := &Debug{MaxPollingInterval: 1000}
+	d.fixup()
+	require.Equal(defaultPollingInterval, d.MinPollingInterval)
+	require.NoError(d.validate())
+
+	d = &Debug{PollingInterval: 500, MinPollingInterval: 100, MaxPollingInterval: 1000}
+	d.fixup()
+	require.Equal(100, d.MinPollingInterval)
+	require.NoError(d.validate())
+
+	// Adaptive polling must never reach a zero interval.
+	d = &Debug{MinPollingInterval: 0, MaxPollingInterval: 1000, RetransmitBackoff: 2}
+	require.Error(d.validate())
+
+	d = &Debug{MinPollingInterval: 2000, MaxPollingInterval: 1000}
+	d.fixup()
+	require.Error(d.validate())
+
+	d = &Debug{MinPollingInterval: -1}
+	d.fixup()
+	require.Error(d.validate())
+}
+
+func TestHealthValidate(t *testing.T) {
+	require := require.New(t)
+
+	for _, addr := range []string{"127.0.0.1:8080", "[::1]:8080", "localhost:8080"} {
+		h := &Health{Address: addr}
+		h.fixup()
+		require.NoError(h.validate(), addr)
+		require.Equal(defaultMaxDocumentAge, h.MaxDocumentAge)
+	}
+	for _, addr := range []string{"127.0.0.1", "0.0.0.0:8080", "192.0.2.1:8080", "example.com:8080"} {
+		require.Error((&Health{Address: addr}).validate(), addr)
+	}
+	require.Error((&Health{Address: "127.0.0.1:8080", MaxDocumentAge: -1}).validate())
+}
+
+func TestAccountLinkKey(t *testing.T) {
+	require := require.New(t)
+
+	acc := &Account{User: "alice", Provider: "acme"}
+	linkKey, err := acc.LinkKey()
+	require.NoError(err)
+	require.Nil(linkKey)
+
+	dir, err := ioutil.TempDir("", "seed")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	masterSeed, err := seed.New()
+	require.NoError(err)
+	phrase, err := seed.Encode(masterSeed)
+	require.NoError(err)
+	acc.SeedFile = filepath.Join(dir, "seed")
+	acc.SeedIndex = 3
+	require.NoError(ioutil.WriteFile(acc.SeedFile, []byte(phrase+"\n"), 0600))
+	require.NoError(acc.validate())
+
+	// The link key is the seed's key for the account index.
+	want, err := seed.LinkKey(masterSeed, 3)
+	require.NoError(err)
+	linkKey, err = acc.LinkKey()
+	require.NoError(err)
+	require.Equal(want.Bytes(), linkKey.Bytes())
+
+	require.NoError(os.Chmod(acc.SeedFile, 0644))
+	_, err = acc.LinkKey()
+	require.Error(err)
+
+	acc.SeedFile = "seed"
+	require.Error(acc.validate())
+}
@@ -0,0 +1,131 @@
+// migrate.go - Katzenpost client configuration migration.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// CurrentSchemaVersion is the version of the configuration file layout
+// understood by this package.
+const CurrentSchemaVersion = 1
+
+var (
+	// Sections of the mail proxy era layout which have no equivalent.
+	obsoleteSections = []string{"Proxy", "Management", "Recipients", "SMTPProxy", "POP3Proxy"}
+
+	// Account keys of the mail proxy era layout which have no equivalent.
+	obsoleteAccountKeys = []string{"Authority", "LinkKey", "IdentityKey", "StorageKey", "InsecureKeyDiscovery"}
+
+	// Debug keys of the mail proxy era layout which have no equivalent.
+	obsoleteDebugKeys = []string{"ReceiveTimeout", "BounceQueueLifetime", "UrgentQueueLifetime", "RetransmitSlack", "SendSlack", "DecoySlack", "DisableTimeSync", "GenerateOnly"}
+)
+
+// migrations[i] converts a version i configuration tree to version i+1,
+// and returns true if the tree was changed.
+var migrations = []func(map[string]interface{}) (bool, error){
+	migrateV0,
+}
+
+// migrate converts a decoded configuration tree of any known schema
+// version to CurrentSchemaVersion in place, and returns true if the tree
+// was changed.
+func migrate(tree map[string]interface{}) (bool, error) {
+	version := 0
+	if v, ok := tree["SchemaVersion"]; ok {
+		i, ok := v.(int64)
+		if !ok {
+			return false, fmt.Errorf("config: SchemaVersion '%v' is not an integer", v)
+		}
+		version = int(i)
+	}
+	if version < 0 || version > CurrentSchemaVersion {
+		return false, fmt.Errorf("config: SchemaVersion %d is unsupported, the latest is %d", version, CurrentSchemaVersion)
+	}
+	migrated := false
+	for ; version < CurrentSchemaVersion; version++ {
+		changed, err := migrations[version](tree)
+		if err != nil {
+			return false, fmt.Errorf("config: failed to migrate from SchemaVersion %d: %v", version, err)
+		}
+		migrated = migrated || changed
+	}
+	if migrated {
+		tree["SchemaVersion"] = int64(CurrentSchemaVersion)
+	}
+	return migrated, nil
+}
+
+// migrateV0 converts the unversioned layouts, including the mail proxy
+// era layout with an Account and Authority list, to version 1.  Other
+// unversioned layouts are already valid version 1 layouts.
+func migrateV0(tree map[string]interface{}) (bool, error) {
+	changed := false
+	obsolete := []string{}
+	findObsolete := func(section map[string]interface{}, prefix string, keys []string) {
+		for _, k := range keys {
+			if _, ok := section[k]; ok {
+				obsolete = append(obsolete, prefix+k)
+			}
+		}
+	}
+	findObsolete(tree, "", obsoleteSections)
+
+	if accounts, ok := tree["Account"].([]map[string]interface{}); ok {
+		if len(accounts) != 1 {
+			return false, fmt.Errorf("found %d Accounts, exactly one is supported", len(accounts))
+		}
+		findObsolete(accounts[0], "Account.", obsoleteAccountKeys)
+		tree["Account"] = accounts[0]
+		changed = true
+	}
+
+	if authorities, ok := tree["Authority"].([]map[string]interface{}); ok {
+		if len(authorities) != 1 {
+			return false, fmt.Errorf("found %d Authorities, exactly one is supported", len(authorities))
+		}
+		// Voting authorities were configured differently, and can not be
+		// migrated automatically.
+		switch scheme := authorities[0]["Scheme"]; scheme {
+		case nil, "nonvoting":
+		case "voting":
+			return false, errors.New("Authority Scheme 'voting' can not be migrated, configure VotingAuthority instead")
+		default:
+			return false, fmt.Errorf("Authority Scheme '%v' is unknown", scheme)
+		}
+		if _, ok := tree["NonvotingAuthority"]; !ok {
+			tree["NonvotingAuthority"] = map[string]interface{}{
+				"Address":   authorities[0]["Address"],
+				"PublicKey": authorities[0]["PublicKey"],
+			}
+		}
+		delete(tree, "Authority")
+		changed = true
+	}
+
+	if debug, ok := tree["Debug"].(map[string]interface{}); ok {
+		findObsolete(debug, "Debug.", obsoleteDebugKeys)
+	}
+
+	if len(obsolete) != 0 {
+		sort.Strings(obsolete)
+		return false, fmt.Errorf("keys are no longer supported and must be removed: %v", obsolete)
+	}
+	return changed, nil
+}
@@ -0,0 +1,132 @@
+// migrate_test.go - Katzenpost client configuration migration tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/require"
+)
+
+const legacyConfig = `
+[UpstreamProxy]
+  Type = "none"
+
+[[Authority]]
+  Identifier = "acme-authority"
+  Scheme = "nonvoting"
+  Address = "127.0.0.1:29483"
+  PublicKey = "o4w1Nyj/nKNwho5SWfAIfh7SMU8FRx52nMHGgYsMHqQ="
+
+[[Account]]
+  User = "alice"
+  Provider = "acme"
+`
+
+func TestLoadCurrentLayout(t *testing.T) {
+	require := require.New(t)
+
+	cfg, err := LoadFile("../testdata/client.toml")
+	require.NoError(err)
+	require.Equal(CurrentSchemaVersion, cfg.SchemaVersion)
+}
+
+func TestMigrateLegacyLayout(t *testing.T) {
+	require := require.New(t)
+
+	cfg, err := Load([]byte(legacyConfig))
+	require.NoError(err)
+	require.Equal(CurrentSchemaVersion, cfg.SchemaVersion)
+	require.Equal("alice", cfg.Account.User)
+	require.Equal("acme", cfg.Account.Provider)
+	require.Equal("127.0.0.1:29483", cfg.NonvotingAuthority.Address)
+
+	_, err = Load([]byte(legacyConfig + `
+[[Account]]
+  User = "bob"
+  Provider = "acme"
+`))
+	require.Error(err)
+
+	_, err = Load([]byte(legacyConfig + `
+[Proxy]
+  DataDir = "/tmp"
+`))
+	require.Error(err)
+	require.Contains(err.Error(), "Proxy")
+}
+
+func TestMigrateAuthorityScheme(t *testing.T) {
+	require := require.New(t)
+
+	authority := func(scheme interface{}) map[string]interface{} {
+		a := map[string]interface{}{
+			"Address":   "127.0.0.1:29483",
+			"PublicKey": "o4w1Nyj/nKNwho5SWfAIfh7SMU8FRx52nMHGgYsMHqQ=",
+		}
+		if scheme != nil {
+			a["Scheme"] = scheme
+		}
+		return a
+	}
+	for _, scheme := range []interface{}{nil, "nonvoting"} {
+		tree := map[string]interface{}{
+			"Authority": []map[string]interface{}{authority(scheme)},
+		}
+		migrated, err := migrate(tree)
+		require.NoError(err)
+		require.True(migrated)
+		require.Contains(tree, "NonvotingAuthority")
+	}
+	for _, scheme := range []interface{}{"voting", "Nonvoting", int64(1)} {
+		tree := map[string]interface{}{
+			"Authority": []map[string]interface{}{authority(scheme)},
+		}
+		_, err := migrate(tree)
+		require.Error(err)
+		require.Contains(err.Error(), "Scheme")
+	}
+}
+
+func TestLoadUnmigrated(t *testing.T) {
+	require := require.New(t)
+
+	// A layout which needs no migration is decoded as given.
+	for _, doc := range []string{"SchemaVersion = 1\n", "[Debug]\n  PollingInterval = 5\n"} {
+		tree := make(map[string]interface{})
+		_, err := toml.Decode(doc, &tree)
+		require.NoError(err)
+		migrated, err := migrate(tree)
+		require.NoError(err)
+		require.False(migrated)
+	}
+
+	// Unknown keys are still reported.
+	_, err := Load([]byte("SchemaVersion = 1\n[Debug]\n  Polling = 5\n"))
+	require.Error(err)
+	require.Contains(err.Error(), "Debug.Polling")
+}
+
+func TestMigrateUnsupportedVersion(t *testing.T) {
+	require := require.New(t)
+
+	_, err := Load([]byte("SchemaVersion = 2\n" + legacyConfig))
+	require.Error(err)
+	_, err = Load([]byte(`SchemaVersion = "one"`))
+	require.Error(err)
+}
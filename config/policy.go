@@ -0,0 +1,112 @@
+// policy.go - Katzenpost client send policies.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PolicyWildcard matches any Recipient or Provider in a SendPolicy.
+const PolicyWildcard = "*"
+
+// MaxCopies bounds the number of copies of each message a SendPolicy
+// may send.
+const MaxCopies = 3
+
+var defaultSendPolicy = SendPolicy{
+	Recipient: PolicyWildcard,
+	Provider:  PolicyWildcard,
+}
+
+// SendPolicy controls how messages to the matching recipients are sent.
+type SendPolicy struct {
+	// Recipient is the recipient the policy applies to, or "*" for
+	// every recipient on the Provider.
+	Recipient string
+
+	// Provider is the recipient's Provider the policy applies to, or
+	// "*" for every Provider.
+	Provider string
+
+	// Reliable enables automatic retransmissions until a SURB-ACK is
+	// received.
+	Reliable bool
+
+	// ForwardOnly sends messages without a SURB, so that no reply or
+	// acknowledgement can be received and no SURB decryption keys are
+	// kept.  It can not be combined with Reliable.
+	ForwardOnly bool
+
+	// Copies is the number of copies of each message to send, each over
+	// an independently chosen route.  Only the first reply is delivered.
+	// Zero is the same as one.
+	Copies int
+}
+
+func (p *SendPolicy) validate() error {
+	if p.Recipient == "" {
+		return errors.New("recipient is missing")
+	}
+	if p.Provider == "" {
+		return errors.New("provider is missing")
+	}
+	if p.Provider == PolicyWildcard && p.Recipient != PolicyWildcard {
+		return errors.New("a recipient can not be matched on any Provider")
+	}
+	if p.ForwardOnly && p.Reliable {
+		return errors.New("a ForwardOnly policy can not be Reliable")
+	}
+	if p.Copies < 0 || p.Copies > MaxCopies {
+		return fmt.Errorf("Copies must be between 0 and %d", MaxCopies)
+	}
+	return nil
+}
+
+// specificity orders policies so that an exact recipient beats a whole
+// Provider, which beats the catch all.
+func (p *SendPolicy) specificity() int {
+	n := 0
+	if p.Recipient != PolicyWildcard {
+		n++
+	}
+	if p.Provider != PolicyWildcard {
+		n++
+	}
+	return n
+}
+
+func (p *SendPolicy) matches(recipient, provider string) bool {
+	return (p.Recipient == PolicyWildcard || p.Recipient == recipient) &&
+		(p.Provider == PolicyWildcard || p.Provider == provider)
+}
+
+// SendPolicyFor returns the most specific SendPolicy matching the
+// recipient, or the default policy if none match.
+func (c *Config) SendPolicyFor(recipient, provider string) *SendPolicy {
+	var best *SendPolicy
+	for _, p := range c.SendPolicy {
+		if p.matches(recipient, provider) && (best == nil || p.specificity() > best.specificity()) {
+			best = p
+		}
+	}
+	if best == nil {
+		policy := defaultSendPolicy
+		return &policy
+	}
+	return best
+}
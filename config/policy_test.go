@@ -0,0 +1,72 @@
+// policy_test.go - Katzenpost client send policy tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendPolicy(t *testing.T) {
+	require := require.New(t)
+
+	cfg, err := Load([]byte(legacyConfig + `
+[[SendPolicy]]
+  Recipient = "*"
+  Provider = "acme"
+  Reliable = true
+
+[[SendPolicy]]
+  Recipient = "bob"
+  Provider = "acme"
+  Reliable = false
+  Copies = 2
+`))
+	require.NoError(err)
+
+	require.True(cfg.SendPolicyFor("alice", "acme").Reliable)
+	require.False(cfg.SendPolicyFor("bob", "acme").Reliable)
+	require.Equal(2, cfg.SendPolicyFor("bob", "acme").Copies)
+	p := cfg.SendPolicyFor("alice", "example")
+	require.False(p.Reliable)
+	require.Equal(PolicyWildcard, p.Provider)
+
+	_, err = Load([]byte(legacyConfig + `
+[[SendPolicy]]
+  Recipient = "bob"
+  Provider = "*"
+`))
+	require.Error(err)
+
+	_, err = Load([]byte(legacyConfig + `
+[[SendPolicy]]
+  Recipient = "*"
+  Provider = "*"
+  Copies = 4
+`))
+	require.Error(err)
+
+	_, err = Load([]byte(legacyConfig + `
+[[SendPolicy]]
+  Recipient = "*"
+  Provider = "*"
+  Reliable = true
+  ForwardOnly = true
+`))
+	require.Error(err)
+}
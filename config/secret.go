@@ -0,0 +1,90 @@
+// secret.go - Katzenpost client configuration secrets.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// secretCommandTimeout is how long a secret command may run before it
+// is killed.
+var secretCommandTimeout = 30 * time.Second
+
+// resolveSecret returns a secret which is either given directly in the
+// configuration, read from an absolute file path that only its owner
+// can read, or printed by a command such as "pass show mixnet/proxy".
+// The command is split on whitespace and run without a shell, and is
+// killed if it runs for longer than secretCommandTimeout.  Its standard
+// error is discarded, as it may echo the secret.  At most one of the
+// sources may be set, and trailing newlines are removed.
+func resolveSecret(value, file, command string) (string, error) {
+	set := 0
+	for _, s := range []string{value, file, command} {
+		if s != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return "", errors.New("only one of the secret, its File and its Command may be set")
+	}
+
+	var b []byte
+	switch {
+	case file != "":
+		if !filepath.IsAbs(file) {
+			return "", fmt.Errorf("secret file '%v' must be an absolute path", file)
+		}
+		fi, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode().Perm()&0077 != 0 {
+			return "", fmt.Errorf("secret file '%v' must not be accessible by other users", file)
+		}
+		if b, err = ioutil.ReadFile(file); err != nil {
+			return "", err
+		}
+	case command != "":
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			return "", errors.New("secret command is empty")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stderr = ioutil.Discard
+		var err error
+		if b, err = cmd.Output(); err != nil {
+			if ctx.Err() != nil {
+				return "", fmt.Errorf("secret command '%v' timed out after %v", args[0], secretCommandTimeout)
+			}
+			return "", fmt.Errorf("secret command '%v' failed: %v", args[0], err)
+		}
+	default:
+		return value, nil
+	}
+	return string(bytes.TrimRight(b, "\r\n")), nil
+}
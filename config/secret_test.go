@@ -0,0 +1,95 @@
+// secret_test.go - Katzenpost client configuration secret tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSecret(t *testing.T) {
+	require := require.New(t)
+
+	secret, err := resolveSecret("hunter2", "", "")
+	require.NoError(err)
+	require.Equal("hunter2", secret)
+
+	secret, err = resolveSecret("", "", "echo hunter2")
+	require.NoError(err)
+	require.Equal("hunter2", secret)
+
+	_, err = resolveSecret("", "", "false")
+	require.Error(err)
+
+	_, err = resolveSecret("", "", " \t ")
+	require.EqualError(err, "secret command is empty")
+
+	timeout := secretCommandTimeout
+	defer func() { secretCommandTimeout = timeout }()
+	secretCommandTimeout = 10 * time.Millisecond
+	_, err = resolveSecret("", "", "sleep 10")
+	require.Contains(err.Error(), "timed out")
+	secretCommandTimeout = timeout
+
+	dir, err := ioutil.TempDir("", "secret")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "password")
+	require.NoError(ioutil.WriteFile(file, []byte("hunter2\n"), 0600))
+
+	secret, err = resolveSecret("", file, "")
+	require.NoError(err)
+	require.Equal("hunter2", secret)
+
+	require.NoError(os.Chmod(file, 0644))
+	_, err = resolveSecret("", file, "")
+	require.Error(err)
+
+	_, err = resolveSecret("", "password", "")
+	require.Error(err)
+
+	_, err = resolveSecret("hunter2", file, "")
+	require.Error(err)
+}
+
+func TestSecretResolvedOnce(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "secret")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "password")
+	require.NoError(ioutil.WriteFile(file, []byte("hunter2\n"), 0600))
+
+	cfg := &Config{UpstreamProxy: &UpstreamProxy{PasswordFile: file}}
+	require.EqualError(cfg.FixupAndMinimallyValidate(), "config: Authority configuration is invalid")
+	require.Equal("hunter2", cfg.UpstreamProxyConfig().Password)
+
+	// Validating again does not read the secret again.
+	require.NoError(os.Remove(file))
+	require.EqualError(cfg.FixupAndMinimallyValidate(), "config: Authority configuration is invalid")
+	require.Equal("hunter2", cfg.UpstreamProxyConfig().Password)
+
+	// A blank command is an error rather than a panic.
+	cfg = &Config{UpstreamProxy: &UpstreamProxy{Type: "socks5", Network: "tcp", Address: "127.0.0.1:9050", PasswordCommand: "  "}}
+	require.EqualError(cfg.FixupAndMinimallyValidate(), "config: UpstreamProxy: Password: secret command is empty")
+}
@@ -42,4 +42,16 @@ const (
 
 	// MaxEgressQueueSize is the maximum size of the egress queue.
 	MaxEgressQueueSize = 40
+
+	// CheckMailCooldown is the minimum interval between forced fetches
+	// of the Provider's receive queue.
+	CheckMailCooldown = 10 * time.Second
+
+	// CheckMailIdle is how long a forced fetch waits without receiving
+	// anything before it is considered complete.
+	CheckMailIdle = 2 * time.Second
+
+	// MaxPendingReplies is the maximum number of sent messages which
+	// may be awaiting a SURB reply at any given time.
+	MaxPendingReplies = 1024
 )
@@ -0,0 +1,97 @@
+// dedup.go - mixnet client duplicate submission detection
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/client/config"
+	cConstants "github.com/katzenpost/client/constants"
+)
+
+type dedupEntry struct {
+	id      *[cConstants.MessageIDLength]byte
+	addedAt time.Time
+}
+
+// dedupFilter remembers recent submissions so that an identical message
+// submitted again within the window is collapsed into the original.
+type dedupFilter struct {
+	sync.Mutex
+
+	window  time.Duration
+	entries map[[sha256.Size]byte]dedupEntry
+}
+
+func newDedupFilter(window time.Duration) *dedupFilter {
+	return &dedupFilter{
+		window:  window,
+		entries: make(map[[sha256.Size]byte]dedupEntry),
+	}
+}
+
+// dedupKey returns the key of a submission, which includes how it is
+// sent so that a message sent again with a different policy is queued.
+func dedupKey(recipient, provider string, policy *config.SendPolicy, message []byte) [sha256.Size]byte {
+	copies := policy.Copies
+	if copies == 0 {
+		copies = 1
+	}
+	mode := fmt.Sprintf("reliable=%v forwardOnly=%v copies=%d", policy.Reliable, policy.ForwardOnly, copies)
+	h := sha256.New()
+	for _, field := range [][]byte{[]byte(recipient), []byte(provider), []byte(mode), message} {
+		var l [8]byte
+		binary.BigEndian.PutUint64(l[:], uint64(len(field)))
+		h.Write(l[:])
+		h.Write(field)
+	}
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// Submit returns the message ID of an identical submission made with the
+// same policy within the window and true.  Otherwise it calls enqueue and
+// records the message ID it returns.  The filter is locked throughout,
+// so that concurrent identical submissions are only queued once.
+func (f *dedupFilter) Submit(now time.Time, recipient, provider string, policy *config.SendPolicy, message []byte, enqueue func() (*[cConstants.MessageIDLength]byte, error)) (*[cConstants.MessageIDLength]byte, bool, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.expire(now)
+	key := dedupKey(recipient, provider, policy, message)
+	if e, ok := f.entries[key]; ok {
+		return e.id, true, nil
+	}
+	id, err := enqueue()
+	if err != nil {
+		return nil, false, err
+	}
+	f.entries[key] = dedupEntry{id: id, addedAt: now}
+	return id, false, nil
+}
+
+func (f *dedupFilter) expire(now time.Time) {
+	for key, e := range f.entries {
+		if now.Sub(e.addedAt) >= f.window {
+			delete(f.entries, key)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// dedup_test.go - mixnet client duplicate submission detection tests
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/client/config"
+	cConstants "github.com/katzenpost/client/constants"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	f := newDedupFilter(time.Minute)
+	now := time.Now()
+	policy := &config.SendPolicy{}
+	id := &[cConstants.MessageIDLength]byte{1}
+	queued := 0
+	enqueue := func() (*[cConstants.MessageIDLength]byte, error) {
+		queued++
+		return id, nil
+	}
+	submit := func(now time.Time, recipient, provider string, policy *config.SendPolicy, message string) bool {
+		got, dup, err := f.Submit(now, recipient, provider, policy, []byte(message), enqueue)
+		assert.NoError(err)
+		assert.Equal(id, got)
+		return dup
+	}
+
+	assert.False(submit(now, "alice", "acme", policy, "hello"))
+	assert.True(submit(now.Add(time.Second), "alice", "acme", policy, "hello"))
+	assert.True(submit(now.Add(time.Second), "alice", "acme", &config.SendPolicy{Copies: 1}, "hello"))
+	assert.Equal(1, queued)
+
+	assert.False(submit(now.Add(time.Second), "alice", "acme", policy, "hello!"))
+	assert.False(submit(now.Add(time.Second), "alicea", "cme", policy, "hello"))
+	assert.False(submit(now.Add(time.Second), "alice", "acme", &config.SendPolicy{Reliable: true}, "hello"))
+	assert.False(submit(now.Add(time.Second), "alice", "acme", &config.SendPolicy{ForwardOnly: true}, "hello"))
+	assert.False(submit(now.Add(time.Second), "alice", "acme", &config.SendPolicy{Copies: 2}, "hello"))
+	assert.False(submit(now.Add(time.Minute), "alice", "acme", policy, "hello"))
+	assert.Equal(7, queued)
+
+	// A submission which fails to be queued is not remembered.
+	queueErr := errors.New("queue is full")
+	_, dup, err := f.Submit(now, "bob", "acme", policy, []byte("hello"), func() (*[cConstants.MessageIDLength]byte, error) {
+		return nil, queueErr
+	})
+	assert.False(dup)
+	assert.Equal(queueErr, err)
+	assert.False(submit(now, "bob", "acme", policy, "hello"))
+}
+
+func TestDedupFilterConcurrent(t *testing.T) {
+	f := newDedupFilter(time.Minute)
+	now := time.Now()
+	var queued uint32
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := f.Submit(now, "alice", "acme", &config.SendPolicy{}, []byte("hello"), func() (*[cConstants.MessageIDLength]byte, error) {
+				atomic.AddUint32(&queued, 1)
+				return &[cConstants.MessageIDLength]byte{1}, nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, uint32(1), queued)
+}
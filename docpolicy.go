@@ -0,0 +1,149 @@
+// docpolicy.go - PKI document policy checks
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/katzenpost/client/constants"
+	"github.com/katzenpost/core/pki"
+)
+
+// DocFindingSeverity is how a DocFinding affects the Session.
+type DocFindingSeverity int
+
+const (
+	// DocWarning findings are reported but do not change behaviour.
+	DocWarning DocFindingSeverity = iota
+
+	// DocDisableDecoys findings disable decoy traffic for as long as the
+	// document is current.  Messages are still sent.
+	DocDisableDecoys
+
+	// DocFatal findings make the document unusable, and the Session
+	// is shut down.
+	DocFatal
+)
+
+// String returns a string representation of the DocFindingSeverity.
+func (s DocFindingSeverity) String() string {
+	switch s {
+	case DocWarning:
+		return "warning"
+	case DocDisableDecoys:
+		return "decoys disabled"
+	case DocFatal:
+		return "fatal"
+	}
+	return fmt.Sprintf("[unknown severity: %d]", int(s))
+}
+
+// DocFinding is a problem found in a PKI document by a DocPolicy.
+type DocFinding struct {
+	// Severity is how the finding affects the Session.
+	Severity DocFindingSeverity
+
+	// Reason describes the finding.
+	Reason string
+}
+
+// String returns a string representation of the DocFinding.
+func (f *DocFinding) String() string {
+	return fmt.Sprintf("%v: %v", f.Severity, f.Reason)
+}
+
+// DocPolicy checks a PKI document and returns its findings, if any.
+type DocPolicy func(doc *pki.Document) []*DocFinding
+
+// loopixDocPolicy checks that the document supports the Loopix decoy
+// traffic and send scheduling used by the Session.
+func loopixDocPolicy(doc *pki.Document) []*DocFinding {
+	findings := []*DocFinding{}
+	if doc.LambdaP <= 0 || doc.LambdaPMaxDelay == 0 {
+		findings = append(findings, &DocFinding{
+			Severity: DocFatal,
+			Reason:   "LambdaP is not set, messages can not be scheduled",
+		})
+	}
+	if doc.LambdaL <= 0 || doc.LambdaLMaxDelay == 0 {
+		findings = append(findings, &DocFinding{
+			Severity: DocDisableDecoys,
+			Reason:   "LambdaL is not set",
+		})
+	}
+	if doc.LambdaD <= 0 || doc.LambdaDMaxDelay == 0 {
+		findings = append(findings, &DocFinding{
+			Severity: DocDisableDecoys,
+			Reason:   "LambdaD is not set",
+		})
+	}
+
+	missing := []string{}
+	for _, provider := range doc.Providers {
+		if _, ok := provider.Kaetzchen[constants.LoopService]; !ok {
+			missing = append(missing, provider.Name)
+		}
+	}
+	switch {
+	case len(missing) == len(doc.Providers):
+		findings = append(findings, &DocFinding{
+			Severity: DocDisableDecoys,
+			Reason:   "no Provider has the loop service",
+		})
+	case len(missing) != 0:
+		findings = append(findings, &DocFinding{
+			Severity: DocWarning,
+			Reason:   fmt.Sprintf("Providers without the loop service: %v", strings.Join(missing, ", ")),
+		})
+	}
+	return findings
+}
+
+// checkDoc applies the document policies to doc, reporting any findings
+// with a DocumentFindingsEvent, and returns an error if the document is
+// unusable.
+func (s *Session) checkDoc(doc *pki.Document) error {
+	findings := []*DocFinding{}
+	for _, policy := range s.docPolicies {
+		findings = append(findings, policy(doc)...)
+	}
+
+	disableDecoys := false
+	fatal := []string{}
+	for _, f := range findings {
+		s.log.Warningf("PKI document for epoch %v: %v", doc.Epoch, f)
+		switch f.Severity {
+		case DocDisableDecoys:
+			disableDecoys = true
+		case DocFatal:
+			fatal = append(fatal, f.Reason)
+		}
+	}
+	if len(findings) != 0 {
+		s.eventCh.In() <- &DocumentFindingsEvent{
+			Epoch:    doc.Epoch,
+			Findings: findings,
+		}
+	}
+	if len(fatal) != 0 {
+		return errors.New("PKI document is not usable: " + strings.Join(fatal, ", "))
+	}
+	s.setDecoysDegraded(disableDecoys)
+	return nil
+}
@@ -0,0 +1,70 @@
+// docpolicy_test.go - PKI document policy check tests
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/katzenpost/client/constants"
+	"github.com/katzenpost/core/pki"
+	"github.com/stretchr/testify/assert"
+)
+
+func testDoc(loopProviders ...bool) *pki.Document {
+	doc := &pki.Document{
+		LambdaP:         0.001,
+		LambdaPMaxDelay: 1000,
+		LambdaL:         0.001,
+		LambdaLMaxDelay: 1000,
+		LambdaD:         0.001,
+		LambdaDMaxDelay: 1000,
+	}
+	for i, hasLoop := range loopProviders {
+		p := &pki.MixDescriptor{
+			Name:      string(rune('a' + i)),
+			Kaetzchen: make(map[string]map[string]interface{}),
+		}
+		if hasLoop {
+			p.Kaetzchen[constants.LoopService] = map[string]interface{}{"endpoint": "loop"}
+		}
+		doc.Providers = append(doc.Providers, p)
+	}
+	return doc
+}
+
+func TestLoopixDocPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Empty(loopixDocPolicy(testDoc(true, true)))
+
+	findings := loopixDocPolicy(testDoc(true, false))
+	assert.Len(findings, 1)
+	assert.Equal(DocWarning, findings[0].Severity)
+	assert.Contains(findings[0].Reason, "b")
+
+	findings = loopixDocPolicy(testDoc(false, false))
+	assert.Len(findings, 1)
+	assert.Equal(DocDisableDecoys, findings[0].Severity)
+
+	doc := testDoc(true)
+	doc.LambdaP = 0
+	doc.LambdaD = 0
+	findings = loopixDocPolicy(doc)
+	assert.Len(findings, 2)
+	assert.Equal(DocFatal, findings[0].Severity)
+	assert.Equal(DocDisableDecoys, findings[1].Severity)
+}
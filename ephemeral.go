@@ -0,0 +1,159 @@
+// ephemeral.go - ephemeral client accounts
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/katzenpost/core/epochtime"
+	"github.com/katzenpost/core/pki"
+)
+
+// EphemeralOptions bounds the use of an ephemeral account.
+type EphemeralOptions struct {
+	// MaxLifetime is the duration after which the account's session is
+	// destroyed.  Zero means the session lives until DestroyEphemeralAccount
+	// is called or the Client is shut down.
+	MaxLifetime time.Duration
+
+	// MaxMessages is the number of messages which may be sent using the
+	// account, after which sends fail with ErrMessageLimitReached.
+	// Zero means unlimited.
+	MaxMessages uint64
+}
+
+// NewEphemeralAccount registers a throwaway account on the named Provider,
+// which must permit open registration, and returns a session using it.
+// The account's link key is generated in memory, is never written to
+// disk and is destroyed along with the session.
+func (c *Client) NewEphemeralAccount(provider string, opts *EphemeralOptions) (*Session, error) {
+	if opts == nil {
+		opts = &EphemeralOptions{}
+	}
+
+	pkiClient, err := c.sharedPKIClient()
+	if err != nil {
+		return nil, err
+	}
+	epoch, _, _ := epochtime.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), initialPKIConsensusTimeout)
+	defer cancel()
+	doc, _, err := pkiClient.Get(ctx, epoch)
+	if err != nil {
+		return nil, err
+	}
+	var desc *pki.MixDescriptor
+	for _, p := range doc.Providers {
+		if p.Name == provider {
+			desc = p
+			break
+		}
+	}
+	if desc == nil {
+		return nil, fmt.Errorf("Provider %v not found in the consensus", provider)
+	}
+
+	cfg := *c.cfg
+	linkKey, err := registerWithProvider(&cfg, desc)
+	if err != nil {
+		return nil, err
+	}
+	c.log.Noticef("Registered ephemeral account on Provider %v", provider)
+
+	timeout := time.Duration(cfg.Debug.SessionDialTimeout) * time.Second
+	dialCtx, dialCancel := context.WithTimeout(context.Background(), timeout)
+	defer dialCancel()
+	// A fatal error of the session only destroys it, not the Client.
+	fatalErrCh := make(chan error, 1)
+	s, err := NewSession(dialCtx, fatalErrCh, c.logBackend, &cfg, linkKey,
+		WithPKIClient(pkiClient), withMessageLimit(opts.MaxMessages))
+	if err != nil {
+		linkKey.Reset()
+		return nil, err
+	}
+
+	var timer *time.Timer
+	if opts.MaxLifetime != 0 {
+		timer = time.AfterFunc(opts.MaxLifetime, func() {
+			c.log.Noticef("Ephemeral account on Provider %v reached its lifetime", provider)
+			c.DestroyEphemeralAccount(s)
+		})
+	}
+	c.ephemeralLock.Lock()
+	c.ephemeral[s] = timer
+	c.ephemeralLock.Unlock()
+	go c.watchEphemeral(s, fatalErrCh)
+	return s, nil
+}
+
+// watchEphemeral destroys the ephemeral session when it reports a fatal
+// error on fatalErrCh, until the session is halted.
+func (c *Client) watchEphemeral(s *Session, fatalErrCh chan error) {
+	for {
+		select {
+		case <-s.HaltCh():
+			return
+		case err := <-fatalErrCh:
+			c.reportError(err)
+			if IsRecoverable(err) {
+				c.log.Warningf("Recoverable error of ephemeral account: %v", err)
+				continue
+			}
+			c.log.Warningf("Destroying ephemeral account due to error: %v", err)
+			c.recordEvent("error", "ephemeral account: %v", err)
+			c.DestroyEphemeralAccount(s)
+			return
+		}
+	}
+}
+
+// DestroyEphemeralAccount shuts down a session created by NewEphemeralAccount
+// and destroys its key material.
+func (c *Client) DestroyEphemeralAccount(s *Session) error {
+	c.ephemeralLock.Lock()
+	timer, ok := c.ephemeral[s]
+	delete(c.ephemeral, s)
+	c.ephemeralLock.Unlock()
+	if !ok {
+		return errors.New("not an ephemeral account session")
+	}
+	if timer != nil {
+		timer.Stop()
+	}
+	s.Shutdown()
+	s.linkKey.Reset()
+	return nil
+}
+
+func (c *Client) ephemeralSessions() []*Session {
+	c.ephemeralLock.Lock()
+	defer c.ephemeralLock.Unlock()
+	sessions := make([]*Session, 0, len(c.ephemeral))
+	for s := range c.ephemeral {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+func (c *Client) destroyAllEphemeral() {
+	for _, s := range c.ephemeralSessions() {
+		c.DestroyEphemeralAccount(s)
+	}
+}
@@ -0,0 +1,86 @@
+// ephemeral_test.go - ephemeral client account tests
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/core/constants"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEphemeralMessageLimit(t *testing.T) {
+	require := require.New(t)
+
+	s := testSession(t, NewManualClock(epoch0), new(fakeMinclient))
+	defer haltTestSession(s)
+	s.egressQueue = NewQueue(1, RejectNewest, s.onEvicted)
+	s.maxMessages = 2
+
+	// Messages which are not queued do not count.
+	_, err := s.SendMessage("bob", "acme", make([]byte, constants.UserForwardPayloadLength))
+	require.Error(err)
+	_, err = s.SendMessage("bob", "acme", []byte("hello"))
+	require.NoError(err)
+	_, err = s.SendMessage("bob", "acme", []byte("hello"))
+	require.Equal(ErrQueueFull, err)
+	require.Equal(uint64(1), s.messageCount)
+
+	_, err = s.egressQueue.Pop()
+	require.NoError(err)
+	_, err = s.SendMessage("bob", "acme", []byte("hello"))
+	require.NoError(err)
+	_, err = s.egressQueue.Pop()
+	require.NoError(err)
+	_, err = s.SendMessage("bob", "acme", []byte("hello"))
+	require.Equal(ErrMessageLimitReached, err)
+	require.Equal(uint64(2), s.messageCount)
+}
+
+func TestEphemeralFatalError(t *testing.T) {
+	require := require.New(t)
+
+	s := testSession(t, NewManualClock(epoch0), new(fakeMinclient))
+	defer haltTestSession(s)
+	fatalErrCh := make(chan error, 1)
+	s.fatalErrCh = fatalErrCh
+	c := &Client{
+		log:       s.log,
+		haltedCh:  make(chan interface{}),
+		ephemeral: map[*Session]*time.Timer{s: nil},
+	}
+	go c.watchEphemeral(s, fatalErrCh)
+
+	// A fatal error destroys the ephemeral session but not the Client.
+	s.fatal(errors.New("impossible failure"))
+	<-s.HaltCh()
+	require.True((<-s.eventCh.Out()).(*ErrorEvent).Fatal)
+	require.Eventually(func() bool {
+		return len(c.ephemeralSessions()) == 0
+	}, time.Second, time.Millisecond)
+	select {
+	case <-c.haltedCh:
+		t.Fatal("Client halted")
+	default:
+	}
+
+	// Once halted, the session reports nothing more.
+	s.fatal(errors.New("impossible failure"))
+	s.fatal(errors.New("impossible failure"))
+}
@@ -0,0 +1,82 @@
+// eventlog.go - Postmortem event log.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"sync/atomic"
+)
+
+// eventLogEntries bounds the number of entries kept in the event log.
+const eventLogEntries = 1024
+
+// Events returns the entries of the event log, oldest first, or nil if
+// no Logging.EventLogFile is configured.
+func (c *Client) Events() []string {
+	if c.eventLog == nil {
+		return nil
+	}
+	return c.eventLog.Entries()
+}
+
+func (c *Client) recordEvent(kind, format string, args ...interface{}) {
+	if c.eventLog == nil {
+		return
+	}
+	if err := c.eventLog.Record(kind, format, args...); err != nil {
+		c.log.Errorf("Failed to write event log: %v", err)
+	}
+}
+
+// recordEvent records the events useful for reconstructing what happened
+// to the Session.  Only state transitions and counts are recorded, never
+// message identifiers or payloads.
+func (s *Session) recordEvent(e Event) {
+	if s.eventLog == nil {
+		return
+	}
+	var err error
+	switch event := e.(type) {
+	case *ConnectionStatusEvent:
+		if event.IsConnected {
+			stats := s.QueueStats()
+			err = s.eventLog.Record("connection", "connected, %d queued, %d awaiting reply, %d received", stats.Pending, stats.AwaitingReply, atomic.LoadUint64(&s.receivedCount))
+		} else {
+			err = s.eventLog.Record("connection", "disconnected: %v", event.Err)
+		}
+	case *NewDocumentEvent:
+		err = s.eventLog.Record("epoch", "new document for epoch %d", event.Document.Epoch)
+	case *DocumentFindingsEvent:
+		err = s.eventLog.Record("document", "epoch %d: %d findings", event.Epoch, len(event.Findings))
+	case *ProviderFailingEvent:
+		err = s.eventLog.Record("provider", "%v failing after %d consecutive failures", event.Provider, event.ConsecutiveFailures)
+	case *ProviderMissingEvent:
+		err = s.eventLog.Record("provider", "%v missing for %d epochs", event.Provider, event.Epochs)
+	case *ProviderKeyWarningEvent:
+		err = s.eventLog.Record("provider", "%v: epoch %d: %v", event.Provider, event.Epoch, event.Reason)
+	case *ErrorEvent:
+		if !event.Fatal {
+			err = s.eventLog.Record("error", "%v", event)
+		}
+	case *MessageSentEvent:
+		if event.Err != nil {
+			err = s.eventLog.Record("send", "failed: %v", event.Err)
+		}
+	}
+	if err != nil {
+		s.log.Errorf("Failed to write event log: %v", err)
+	}
+}
@@ -116,3 +116,94 @@ type NewDocumentEvent struct {
 func (e *NewDocumentEvent) String() string {
 	return fmt.Sprintf("PKI Document for epoch %d", e.Document.Epoch)
 }
+
+// DocumentFindingsEvent is the event sent when the document policies
+// find problems with a new PKI document.
+type DocumentFindingsEvent struct {
+	// Epoch is the epoch of the document.
+	Epoch uint64
+
+	// Findings are the problems found with the document.
+	Findings []*DocFinding
+}
+
+// String returns a string representation of a DocumentFindingsEvent.
+func (e *DocumentFindingsEvent) String() string {
+	return fmt.Sprintf("DocumentFindings: epoch %d: %v", e.Epoch, e.Findings)
+}
+
+// ProviderFailingEvent is the event sent when messages to a Provider's
+// services have consistently failed.
+type ProviderFailingEvent struct {
+	// Provider is the name of the failing Provider.
+	Provider string
+
+	// ConsecutiveFailures is the number of failures since the last
+	// reply from the Provider.
+	ConsecutiveFailures uint64
+}
+
+// String returns a string representation of a ProviderFailingEvent.
+func (e *ProviderFailingEvent) String() string {
+	return fmt.Sprintf("ProviderFailing: %v (%d consecutive failures)", e.Provider, e.ConsecutiveFailures)
+}
+
+// ProviderMissingEvent is the event sent when the account's Provider has
+// been absent from the PKI document for several consecutive epochs.
+type ProviderMissingEvent struct {
+	// Provider is the name of the account's Provider.
+	Provider string
+
+	// Epochs is the number of consecutive epochs it has been missing.
+	Epochs int
+}
+
+// String returns a string representation of a ProviderMissingEvent.
+func (e *ProviderMissingEvent) String() string {
+	return fmt.Sprintf("ProviderMissing: %v (%d epochs)", e.Provider, e.Epochs)
+}
+
+// ProviderKeyWarningEvent is the event sent when the keys the account's
+// Provider publishes are about to stop working with this client.
+type ProviderKeyWarningEvent struct {
+	// Provider is the name of the account's Provider.
+	Provider string
+
+	// Epoch is the epoch of the PKI document.
+	Epoch uint64
+
+	// Reason describes the problem with the keys.
+	Reason string
+}
+
+// String returns a string representation of a ProviderKeyWarningEvent.
+func (e *ProviderKeyWarningEvent) String() string {
+	return fmt.Sprintf("ProviderKeyWarning: %v: epoch %d: %v", e.Provider, e.Epoch, e.Reason)
+}
+
+// ErrorEvent is the event sent when a component of the Session fails.
+type ErrorEvent struct {
+	// Component is the name of the component which failed, if known.
+	Component string
+
+	// Err is the error.
+	Err error
+
+	// Fatal is true if the error shuts down the Client.
+	Fatal bool
+
+	// Restarts is the number of consecutive restarts of the component,
+	// or zero if it was not restarted.
+	Restarts int
+}
+
+// String returns a string representation of an ErrorEvent.
+func (e *ErrorEvent) String() string {
+	switch {
+	case e.Fatal:
+		return fmt.Sprintf("Error: fatal: %v", e.Err)
+	case e.Restarts > 0:
+		return fmt.Sprintf("Error: %v restart %d: %v", e.Component, e.Restarts, e.Err)
+	}
+	return fmt.Sprintf("Error: %v recovered: %v", e.Component, e.Err)
+}
@@ -12,6 +12,7 @@ require (
 	github.com/katzenpost/registration_client v0.0.2
 	github.com/katzenpost/server v0.0.21 // indirect
 	github.com/stretchr/testify v1.4.0
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	golang.org/x/net v0.0.0-20200501053045-e0ff5e5a1de5
 	golang.org/x/text v0.3.2
 	gopkg.in/eapache/channels.v1 v1.1.0
@@ -0,0 +1,126 @@
+// health.go - mixnet client health endpoints
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/katzenpost/core/epochtime"
+)
+
+// startHealthServer serves the health endpoints on the configured
+// loopback address:
+//
+//	/healthz  200 while the Client is running.
+//	/readyz   200 once connected to the Provider with a fresh PKI
+//	          document, otherwise 503 with the reason.
+//	/metrics  The queue statistics of every account in the OpenMetrics
+//	          text format.
+func (c *Client) startHealthServer() error {
+	l, err := net.Listen("tcp", c.cfg.Health.Address)
+	if err != nil {
+		return err
+	}
+	c.healthServer = &http.Server{Handler: c.healthHandler()}
+	go func() {
+		if err := c.healthServer.Serve(l); err != http.ErrServerClosed {
+			c.log.Errorf("Health endpoint failed: %v", err)
+		}
+	}()
+	c.log.Noticef("Serving health endpoints on %v", l.Addr())
+	return nil
+}
+
+func (c *Client) healthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := c.readiness(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok\n")
+	})
+	mux.HandleFunc("/metrics", c.serveMetrics)
+	return mux
+}
+
+// readiness returns nil if the Client is ready to send and receive
+// messages, or the reason it is not.
+func (c *Client) readiness() error {
+	s := c.getSession()
+	if s == nil {
+		return ErrNoSession
+	}
+	if !s.IsConnected() {
+		return errors.New("not connected to the Provider")
+	}
+	doc := s.CurrentDocument()
+	if doc == nil {
+		return errors.New("no PKI document")
+	}
+	epoch, _, _ := epochtime.Now()
+	if age := int64(epoch) - int64(doc.Epoch); age > int64(c.cfg.Health.MaxDocumentAge) {
+		return fmt.Errorf("PKI document is %d epochs old", age)
+	}
+	return nil
+}
+
+func (c *Client) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	connected := 0
+	if c.readiness() == nil {
+		connected = 1
+	}
+	fmt.Fprintf(w, "# TYPE katzenpost_client_ready gauge\nkatzenpost_client_ready %d\n", connected)
+
+	// The queue statistics of each account are labelled with its link
+	// key fingerprint.
+	all := c.AllQueueStats()
+	accounts := make([]string, 0, len(all))
+	for account := range all {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	metric := func(name, kind string, value func(*QueueStats) interface{}) {
+		if len(accounts) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+		if kind == "counter" {
+			name += "_total"
+		}
+		for _, account := range accounts {
+			fmt.Fprintf(w, "%s{account=\"%s\"} %v\n", name, account, value(all[account]))
+		}
+	}
+	metric("katzenpost_client_queue_pending", "gauge", func(s *QueueStats) interface{} { return s.Pending })
+	metric("katzenpost_client_queue_bytes", "gauge", func(s *QueueStats) interface{} { return s.Bytes })
+	metric("katzenpost_client_queue_oldest_age_seconds", "gauge", func(s *QueueStats) interface{} { return s.OldestAge.Seconds() })
+	metric("katzenpost_client_retransmits_pending", "gauge", func(s *QueueStats) interface{} { return s.PendingRetransmits })
+	metric("katzenpost_client_awaiting_reply", "gauge", func(s *QueueStats) interface{} { return s.AwaitingReply })
+	metric("katzenpost_client_expired_replies", "counter", func(s *QueueStats) interface{} { return s.ExpiredReplies })
+	metric("katzenpost_client_queue_rejected", "counter", func(s *QueueStats) interface{} { return s.Rejected })
+	io.WriteString(w, "# EOF\n")
+}
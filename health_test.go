@@ -0,0 +1,87 @@
+// health_test.go - mixnet client health endpoint tests
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/katzenpost/client/config"
+	"github.com/katzenpost/core/epochtime"
+	"github.com/katzenpost/core/pki"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthHandler(t *testing.T) {
+	require := require.New(t)
+
+	c := &Client{cfg: &config.Config{Health: &config.Health{MaxDocumentAge: 1}}}
+	handler := c.healthHandler()
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	require.Equal(http.StatusOK, get("/healthz").Code)
+	w := get("/readyz")
+	require.Equal(http.StatusServiceUnavailable, w.Code)
+	require.Contains(w.Body.String(), ErrNoSession.Error())
+	require.Contains(get("/metrics").Body.String(), "katzenpost_client_ready 0\n")
+
+	// The session is read concurrently with it being set.
+	mc := new(fakeMinclient)
+	s := testSession(t, NewManualClock(epoch0), mc)
+	defer haltTestSession(s)
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		get("/readyz")
+	}()
+	c.sessionLock.Lock()
+	c.session = s
+	c.sessionLock.Unlock()
+	wg.Wait()
+
+	w = get("/readyz")
+	require.Equal(http.StatusServiceUnavailable, w.Code)
+	require.Contains(w.Body.String(), "not connected")
+
+	s.connected = 1
+	w = get("/readyz")
+	require.Equal(http.StatusServiceUnavailable, w.Code)
+	require.Contains(w.Body.String(), "no PKI document")
+
+	epoch, _, _ := epochtime.Now()
+	mc.doc = &pki.Document{Epoch: epoch - 2}
+	w = get("/readyz")
+	require.Equal(http.StatusServiceUnavailable, w.Code)
+	require.Contains(w.Body.String(), "2 epochs old")
+
+	mc.doc = &pki.Document{Epoch: epoch}
+	require.Equal(http.StatusOK, get("/readyz").Code)
+	require.NoError(s.egressQueue.Push(testMessage(1, "bob")))
+	metrics := get("/metrics").Body.String()
+	require.Contains(metrics, "katzenpost_client_ready 1\n")
+	account := s.Info().LinkKeyFingerprint
+	require.Contains(metrics, "katzenpost_client_queue_pending{account=\""+account+"\"} 1\n")
+	require.Contains(metrics, "katzenpost_client_queue_rejected_total{account=\""+account+"\"} 0\n")
+	require.Contains(metrics, "# EOF\n")
+}
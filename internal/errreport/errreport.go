@@ -0,0 +1,137 @@
+// errreport.go - Sanitized local error reports.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package errreport aggregates critical errors into a sanitized report
+// file which the user may choose to submit.  Nothing is ever transmitted.
+package errreport
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// minWriteInterval rate limits rewriting the report file.
+	minWriteInterval = time.Minute
+
+	// maxEntries bounds the number of distinct errors in the report.
+	maxEntries = 64
+)
+
+var (
+	addressRe = regexp.MustCompile(`[^\s<>"']+@[^\s<>"']+`)
+	ipRe      = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`)
+	hexRe     = regexp.MustCompile(`\b[0-9a-fA-F]{8,}\b`)
+	base64Re  = regexp.MustCompile(`[A-Za-z0-9+/]{16,}={0,2}`)
+)
+
+// Sanitize strips identifiers, addresses and key material from msg.
+func Sanitize(msg string) string {
+	msg = addressRe.ReplaceAllString(msg, "<address>")
+	msg = ipRe.ReplaceAllString(msg, "<ip>")
+	msg = hexRe.ReplaceAllString(msg, "<hex>")
+	return base64Re.ReplaceAllStringFunc(msg, func(s string) string {
+		// Long words are not key material.
+		if strings.IndexAny(s, "0123456789+/=") < 0 {
+			return s
+		}
+		return "<base64>"
+	})
+}
+
+type entry struct {
+	count     uint64
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// Reporter aggregates errors and writes them to a report file.
+type Reporter struct {
+	sync.Mutex
+
+	path      string
+	entries   map[string]*entry
+	dropped   uint64
+	lastWrite time.Time
+}
+
+// New returns a Reporter writing to the file at path.
+func New(path string) *Reporter {
+	return &Reporter{
+		path:    path,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Record adds the sanitized error to the report, rewriting the report
+// file if it has not been written recently.
+func (r *Reporter) Record(err error) error {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	msg := Sanitize(err.Error())
+	e, ok := r.entries[msg]
+	if !ok {
+		if len(r.entries) >= maxEntries {
+			r.dropped++
+			return nil
+		}
+		e = &entry{firstSeen: now}
+		r.entries[msg] = e
+	}
+	e.count++
+	e.lastSeen = now
+
+	if now.Sub(r.lastWrite) < minWriteInterval {
+		return nil
+	}
+	return r.write(now)
+}
+
+// Flush writes the report file.
+func (r *Reporter) Flush() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.write(time.Now())
+}
+
+func (r *Reporter) write(now time.Time) error {
+	msgs := make([]string, 0, len(r.entries))
+	for msg := range r.entries {
+		msgs = append(msgs, msg)
+	}
+	sort.Strings(msgs)
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "# Katzenpost client error report, generated %v\n", now.UTC().Format(time.RFC3339))
+	fmt.Fprintf(buf, "# count\tfirst seen\tlast seen\terror\n")
+	for _, msg := range msgs {
+		e := r.entries[msg]
+		fmt.Fprintf(buf, "%d\t%v\t%v\t%s\n", e.count, e.firstSeen.UTC().Format(time.RFC3339), e.lastSeen.UTC().Format(time.RFC3339), msg)
+	}
+	if r.dropped != 0 {
+		fmt.Fprintf(buf, "# %d further errors were not recorded\n", r.dropped)
+	}
+	r.lastWrite = now
+	return ioutil.WriteFile(r.path, buf.Bytes(), 0600)
+}
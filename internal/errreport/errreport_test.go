@@ -0,0 +1,59 @@
+// errreport_test.go - Sanitized local error report tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package errreport
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitize(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal("failed to dial <ip>", Sanitize("failed to dial 127.0.0.1:29483"))
+	require.Equal("no reply for <hex>", Sanitize("no reply for 9f86d081884c7d659a2feaa0c55ad015"))
+	require.Equal("unknown user <address>", Sanitize("unknown user alice@acme"))
+	require.Equal("bad key <base64>", Sanitize("bad key o4w1Nyj/nKNwho5SWfAIfh7SMU8FRx52nMHGgYsMHqQ="))
+	require.Equal("misconfigurations happen", Sanitize("misconfigurations happen"))
+}
+
+func TestReporter(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "errreport")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "report.txt")
+	r := New(path)
+	require.NoError(r.Record(errors.New("no reply for 9f86d081884c7d65")))
+	require.NoError(r.Record(errors.New("no reply for 0123456789abcdef")))
+	require.NoError(r.Flush())
+
+	b, err := ioutil.ReadFile(path)
+	require.NoError(err)
+	report := string(b)
+	require.Contains(report, "2\t")
+	require.Contains(report, "no reply for <hex>")
+	require.False(strings.Contains(report, "9f86d081884c7d65"))
+}
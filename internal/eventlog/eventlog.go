@@ -0,0 +1,120 @@
+// eventlog.go - Bounded on-disk event log.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package eventlog keeps a bounded log of session events in a file, so
+// that the events leading up to a failure survive a crash.  Entries are
+// sanitized and never contain message payloads.
+package eventlog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/client/internal/errreport"
+)
+
+// Log is a bounded event log backed by a file.  Each entry is appended
+// to the file as it is recorded, and the file is compacted to the most
+// recent entries once it holds twice the bound.
+type Log struct {
+	sync.Mutex
+
+	path       string
+	maxEntries int
+	entries    []string
+}
+
+// Open opens the event log at path, keeping at most maxEntries entries,
+// and loads the entries already in it.
+func Open(path string, maxEntries int) (*Log, error) {
+	l := &Log{
+		path:       path,
+		maxEntries: maxEntries,
+	}
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for scanner.Scan() {
+			l.entries = append(l.entries, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return l, l.compact()
+}
+
+// Record appends an entry of the given kind to the log.
+func (l *Log) Record(kind, format string, args ...interface{}) error {
+	l.Lock()
+	defer l.Unlock()
+
+	detail := strings.Replace(errreport.Sanitize(fmt.Sprintf(format, args...)), "\n", " ", -1)
+	line := fmt.Sprintf("%v\t%v\t%v", time.Now().UTC().Format(time.RFC3339), kind, detail)
+	l.entries = append(l.entries, line)
+	if len(l.entries) >= 2*l.maxEntries {
+		return l.compact()
+	}
+
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f, line)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Entries returns the most recent entries in the log, oldest first.
+func (l *Log) Entries() []string {
+	l.Lock()
+	defer l.Unlock()
+	n := len(l.entries)
+	if n > l.maxEntries {
+		n = l.maxEntries
+	}
+	entries := make([]string, n)
+	copy(entries, l.entries[len(l.entries)-n:])
+	return entries
+}
+
+// compact rewrites the file with only the most recent entries.
+func (l *Log) compact() error {
+	if len(l.entries) > l.maxEntries {
+		l.entries = append([]string{}, l.entries[len(l.entries)-l.maxEntries:]...)
+	}
+	buf := new(bytes.Buffer)
+	for _, line := range l.entries {
+		fmt.Fprintln(buf, line)
+	}
+	tmp := l.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
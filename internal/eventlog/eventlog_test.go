@@ -0,0 +1,78 @@
+// eventlog_test.go - Bounded on-disk event log tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package eventlog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventLog(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "eventlog")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events")
+
+	l, err := Open(path, 3)
+	require.NoError(err)
+	for i := 0; i < 5; i++ {
+		require.NoError(l.Record("connection", "attempt %d", i))
+	}
+	require.NoError(l.Record("fatal", "dial 192.0.2.1:29483 failed"))
+
+	entries := l.Entries()
+	require.Len(entries, 3)
+	assert.True(strings.HasSuffix(entries[0], "\tconnection\tattempt 3"))
+	assert.True(strings.HasSuffix(entries[2], "\tfatal\tdial <ip> failed"))
+
+	// The entries survive reopening the log.
+	l, err = Open(path, 3)
+	require.NoError(err)
+	assert.Equal(entries, l.Entries())
+
+	b, err := ioutil.ReadFile(path)
+	require.NoError(err)
+	assert.Equal(3, strings.Count(string(b), "\n"))
+}
+
+func BenchmarkRecord(b *testing.B) {
+	dir, err := ioutil.TempDir("", "eventlog")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := Open(filepath.Join(dir, "events"), 1024)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := l.Record("connection", "attempt %d", i); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
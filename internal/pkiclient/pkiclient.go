@@ -25,6 +25,7 @@ import (
 	"sync"
 
 	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/epochtime"
 	"github.com/katzenpost/core/pki"
 	"github.com/katzenpost/core/worker"
 )
@@ -118,6 +119,26 @@ func (c *Client) Deserialize(raw []byte) (*pki.Document, error) {
 	return c.impl.Deserialize(raw) // I hope impl.Deserialize is re-entrant.
 }
 
+// Seed inserts a serialized PKI document, such as a bundled or previously
+// cached consensus, so that it is used instead of fetching the document
+// for its epoch.  The document is verified by the underlying pki.Client
+// and must be for the current or the next epoch.
+func (c *Client) Seed(raw []byte) error {
+	d, err := c.impl.Deserialize(raw)
+	if err != nil {
+		return err
+	}
+	now, _, _ := epochtime.Now()
+	if d.Epoch != now && d.Epoch != now+1 {
+		return fmt.Errorf("pkiclient: seed document is for epoch %v, current epoch is %v", d.Epoch, now)
+	}
+	if c.cacheGet(d.Epoch) != nil {
+		return nil
+	}
+	c.insertLRU(&cacheEntry{doc: d, raw: raw})
+	return nil
+}
+
 func (c *Client) cacheGet(epoch uint64) *cacheEntry {
 	c.Lock()
 	defer c.Unlock()
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/epochtime"
 	"github.com/katzenpost/core/pki"
+	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
 )
@@ -48,3 +50,36 @@ func TestPKIClient(t *testing.T) {
 	case <-pass:
 	}
 }
+
+type seedPKI struct {
+	mockPKI
+	epoch uint64
+}
+
+func (m seedPKI) Deserialize(raw []byte) (*pki.Document, error) {
+	return &pki.Document{Epoch: m.epoch}, nil
+}
+
+func TestPKIClientSeed(t *testing.T) {
+	assert := assert.New(t)
+
+	now, _, _ := epochtime.Now()
+
+	c := New(seedPKI{epoch: now - 1})
+	err := c.Seed([]byte("stale"))
+	assert.Error(err)
+	c.Halt()
+
+	c = New(seedPKI{epoch: now})
+	defer c.Halt()
+	err = c.Seed([]byte("seed"))
+	assert.NoError(err)
+
+	// The underlying client fails every Get, so this must be a cache hit.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	doc, raw, err := c.Get(ctx, now)
+	assert.NoError(err)
+	assert.Equal(now, doc.Epoch)
+	assert.Equal([]byte("seed"), raw)
+}
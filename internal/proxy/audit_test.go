@@ -0,0 +1,105 @@
+// audit_test.go - Direct dial audit.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package proxy
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// directDials are the identifiers, by import path, which make outgoing
+// connections without the upstream proxy.
+var directDials = map[string][]string{
+	"net":        {"Dial", "DialIP", "DialTCP", "DialTimeout", "DialUDP", "DialUnix", "Dialer"},
+	"crypto/tls": {"Dial", "DialWithDialer", "Dialer"},
+	"net/http":   {"Client", "DefaultClient", "DefaultTransport", "Get", "Head", "Post", "PostForm", "Transport"},
+}
+
+// TestNoDirectDials fails if any code outside this package dials the
+// network directly, rather than with a DialContextFn obtained from the
+// upstream proxy configuration.
+func TestNoDirectDials(t *testing.T) {
+	require := require.New(t)
+
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	require.NoError(err)
+	self, err := filepath.Abs(".")
+	require.NoError(err)
+
+	found := []string{}
+	fset := token.NewFileSet()
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path == self || info.Name() == "vendor" || strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+
+		// Map the file's import names to the identifiers to flag.
+		flagged := make(map[string]map[string]bool)
+		for _, imp := range f.Imports {
+			importPath, _ := strconv.Unquote(imp.Path.Value)
+			names, ok := directDials[importPath]
+			if !ok {
+				continue
+			}
+			name := filepath.Base(importPath)
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			flagged[name] = make(map[string]bool)
+			for _, n := range names {
+				flagged[name][n] = true
+			}
+		}
+		if len(flagged) == 0 {
+			return nil
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if pkg, ok := sel.X.(*ast.Ident); ok && flagged[pkg.Name][sel.Sel.Name] {
+				found = append(found, fset.Position(sel.Pos()).String()+": "+pkg.Name+"."+sel.Sel.Name)
+			}
+			return true
+		})
+		return nil
+	})
+	require.NoError(err)
+	require.Empty(found, "direct dials bypass the upstream proxy: %v", found)
+}
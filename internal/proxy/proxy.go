@@ -120,6 +120,12 @@ func (cfg *Config) FixupAndValidate() error {
 	return nil
 }
 
+// IsSOCKS5 returns true iff outgoing connections are made through a
+// SOCKS5 proxy, whose address is given by Network and Address.
+func (cfg *Config) IsSOCKS5() bool {
+	return cfg.Type == typeSocks5 || cfg.Type == typeTorSocks5
+}
+
 // ToDialContext returns a function matching Dialer.DialContext() that will
 // utilize the configured proxy or nil iff no proxy is configured.
 func (cfg *Config) ToDialContext(tag string) DialContextFn {
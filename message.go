@@ -17,10 +17,12 @@
 package client
 
 import (
+	"sync/atomic"
 	"time"
 
 	cConstants "github.com/katzenpost/client/constants"
 	sConstants "github.com/katzenpost/core/sphinx/constants"
+	"github.com/katzenpost/core/utils"
 )
 
 // Message is a message reference which is used to match future
@@ -38,6 +40,9 @@ type Message struct {
 	// Payload is the message payload
 	Payload []byte
 
+	// QueuedAt contains the time the message was enqueued for sending.
+	QueuedAt time.Time
+
 	// SentAt contains the time the message was sent.
 	SentAt time.Time
 
@@ -71,8 +76,49 @@ type Message struct {
 
 	// Retransmissions counts the number of times the message has been retransmitted.
 	Retransmissions uint32
+
+	// pathETA is the delay of the path the message was last sent over,
+	// which a reliable message waits for after ReplyETA before being
+	// retransmitted.
+	pathETA time.Duration
+
+	// group links the redundant copies of the message, if any.
+	group *sendGroup
+
+	// isCopy is true for a redundant copy of a message, which shares
+	// the original's ID and retransmission state.
+	isCopy bool
+}
+
+// sendGroup links the redundant copies of a message so that only the
+// first reply to any of them is delivered.
+type sendGroup struct {
+	replied uint32
+}
+
+// reply returns true for the first reply to the group.
+func (g *sendGroup) reply() bool {
+	return atomic.CompareAndSwapUint32(&g.replied, 0, 1)
+}
+
+// isReplied returns true if a copy has been replied to.
+func (g *sendGroup) isReplied() bool {
+	return atomic.LoadUint32(&g.replied) != 0
 }
 
 func (m *Message) Priority() uint64 {
 	return m.QueuePriority
 }
+
+// wipeKey zeroes the SURB decryption key once it is no longer needed.
+func (m *Message) wipeKey() {
+	utils.ExplicitBzero(m.Key)
+	m.Key = nil
+}
+
+// wipe zeroes the SURB decryption key and the payload once the
+// message will no longer be sent or replied to.
+func (m *Message) wipe() {
+	m.wipeKey()
+	utils.ExplicitBzero(m.Payload)
+}
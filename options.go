@@ -0,0 +1,296 @@
+// options.go - mixnet client session options
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	mrand "math/rand"
+	"time"
+
+	"github.com/katzenpost/client/internal/eventlog"
+	"github.com/katzenpost/client/utils"
+	"github.com/katzenpost/core/pki"
+	sConstants "github.com/katzenpost/core/sphinx/constants"
+	"github.com/katzenpost/minclient"
+)
+
+// Clock is the source of the current time used by a Session.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Minclient is the interface of the minclient.Client methods used by a
+// Session, so that tests and embedders may substitute their own.
+type Minclient interface {
+	// SendCiphertext sends a message with a SURB, returning the SURB
+	// decryption key and the path delay.
+	SendCiphertext(recipient, provider string, surbID *[sConstants.SURBIDLength]byte, b []byte) ([]byte, time.Duration, error)
+
+	// SendUnreliableCiphertext sends a message without a SURB.
+	SendUnreliableCiphertext(recipient, provider string, b []byte) error
+
+	// CurrentDocument returns the current PKI document, or nil.
+	CurrentDocument() *pki.Document
+
+	// ClockSkew returns the estimated clock skew against the Provider.
+	ClockSkew() time.Duration
+
+	// ForceFetch wakes the Provider polling loop.
+	ForceFetch()
+
+	// SetPollInterval sets the Provider polling interval.
+	SetPollInterval(interval time.Duration)
+
+	// Shutdown and Wait terminate the client.
+	Shutdown()
+	Wait()
+}
+
+var _ Minclient = (*minclient.Client)(nil)
+
+// MinclientFactory constructs the Minclient used by a Session from the
+// configuration the Session would otherwise use itself.
+type MinclientFactory func(*minclient.ClientConfig) (Minclient, error)
+
+func newMinclient(cfg *minclient.ClientConfig) (Minclient, error) {
+	c, err := minclient.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DecoyPayloadFn fills in the payload of a decoy message.  The payload
+// length is fixed so that decoys remain indistinguishable on the wire.
+type DecoyPayloadFn func(payload []byte) error
+
+// DecoyServiceFn selects the destination of a decoy message from the
+// loop services found in the current PKI document, or returns nil to
+// skip the decoy.
+type DecoyServiceFn func(loopServices []utils.ServiceDescriptor) *utils.ServiceDescriptor
+
+// OutgoingFilter transforms the content of outgoing messages before
+// they are padded and encrypted.  Filters are given the destination so
+// that they may apply per-recipient policy, and may reject a message by
+// returning an error.
+type OutgoingFilter interface {
+	Filter(recipient, provider string, message []byte) ([]byte, error)
+}
+
+// OutgoingFilterFunc is an adapter to allow the use of ordinary
+// functions as an OutgoingFilter.
+type OutgoingFilterFunc func(recipient, provider string, message []byte) ([]byte, error)
+
+// Filter calls f(recipient, provider, message).
+func (f OutgoingFilterFunc) Filter(recipient, provider string, message []byte) ([]byte, error) {
+	return f(recipient, provider, message)
+}
+
+// RawMessageHandler is called with each message retrieved from the
+// Provider's spool, before any processing by the Session.
+type RawMessageHandler func(ciphertextBlock []byte)
+
+func zeroDecoyPayload(payload []byte) error {
+	return nil
+}
+
+func randomDecoyService(loopServices []utils.ServiceDescriptor) *utils.ServiceDescriptor {
+	return &loopServices[mrand.Intn(len(loopServices))]
+}
+
+type sessionOptions struct {
+	pkiClient        pki.Client
+	minclientFactory MinclientFactory
+	clock            Clock
+	maxMessages      uint64
+	decoyPayloadFn   DecoyPayloadFn
+	decoyServiceFn   DecoyServiceFn
+	pkiSeed          []byte
+	rawHandler       RawMessageHandler
+	dedupWindow      time.Duration
+	egressQueue      EgressQueue
+	docPolicies      []DocPolicy
+	arqStore         ARQStore
+	outgoingFilters  []OutgoingFilter
+	eventLog         *eventlog.Log
+	proofHandler     DeliveryProofHandler
+	scheduleRng      *mrand.Rand
+}
+
+func newSessionOptions(opts []SessionOption) *sessionOptions {
+	o := &sessionOptions{
+		minclientFactory: newMinclient,
+		clock:            systemClock{},
+		decoyPayloadFn:   zeroDecoyPayload,
+		decoyServiceFn:   randomDecoyService,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// SessionOption is an optional argument to NewSession.
+type SessionOption func(*sessionOptions)
+
+// WithPKIClient makes the Session use the given pki.Client for its own
+// lookups and for minclient, instead of constructing new clients from
+// the configured authority.
+func WithPKIClient(pkiClient pki.Client) SessionOption {
+	return func(o *sessionOptions) {
+		o.pkiClient = pkiClient
+	}
+}
+
+// WithMinclientFactory makes the Session construct its Minclient with the
+// given factory, allowing tests and embedders to substitute their own
+// transport or a fake.
+func WithMinclientFactory(factory MinclientFactory) SessionOption {
+	return func(o *sessionOptions) {
+		o.minclientFactory = factory
+	}
+}
+
+// WithClock makes the Session use the given Clock as its source of
+// the current time.  If the Clock is a TimerClock, such as a ManualClock,
+// it also drives the Session's send schedule, retransmissions and
+// housekeeping timers.
+func WithClock(clock Clock) SessionOption {
+	return func(o *sessionOptions) {
+		o.clock = clock
+	}
+}
+
+// WithPKISeed provides a serialized PKI document, such as one bundled
+// with an application or saved from a previous run, which the Session
+// uses instead of waiting on the authority if it is valid for the
+// current epoch.
+func WithPKISeed(raw []byte) SessionOption {
+	return func(o *sessionOptions) {
+		o.pkiSeed = raw
+	}
+}
+
+// WithDecoyPayloadFn makes the Session generate decoy payloads with the
+// given function instead of sending all zero payloads.
+func WithDecoyPayloadFn(fn DecoyPayloadFn) SessionOption {
+	return func(o *sessionOptions) {
+		o.decoyPayloadFn = fn
+	}
+}
+
+// WithDecoyServiceFn makes the Session select the destination of each
+// decoy message with the given function instead of uniformly at random.
+func WithDecoyServiceFn(fn DecoyServiceFn) SessionOption {
+	return func(o *sessionOptions) {
+		o.decoyServiceFn = fn
+	}
+}
+
+// WithRawMessageHandler makes the Session pass each message retrieved
+// from the Provider's spool to the given handler.  The handler is called
+// from the minclient's receive path and must not block.
+func WithRawMessageHandler(fn RawMessageHandler) SessionOption {
+	return func(o *sessionOptions) {
+		o.rawHandler = fn
+	}
+}
+
+// WithDedupWindow makes the Session collapse a message submitted with
+// the same recipient, Provider, SendPolicy and content as one submitted
+// within the window into the original, returning the original message ID instead
+// of sending it again.  This protects against callers which resubmit
+// after a timeout.  The blocking send methods are not deduplicated.
+func WithDedupWindow(window time.Duration) SessionOption {
+	return func(o *sessionOptions) {
+		o.dedupWindow = window
+	}
+}
+
+// WithEgressQueue makes the Session queue outgoing messages in the given
+// EgressQueue, such as a durable implementation, instead of constructing
+// an in-memory Queue from the configuration.
+func WithEgressQueue(q EgressQueue) SessionOption {
+	return func(o *sessionOptions) {
+		o.egressQueue = q
+	}
+}
+
+// WithDocPolicy adds a DocPolicy which is applied to every PKI document
+// in addition to the built in checks of the Loopix parameters.
+func WithDocPolicy(policy DocPolicy) SessionOption {
+	return func(o *sessionOptions) {
+		o.docPolicies = append(o.docPolicies, policy)
+	}
+}
+
+// WithARQStore makes the Session persist the retransmission state of
+// reliable messages in the given ARQStore, and retransmit the messages
+// found in it when the Session is created.
+func WithARQStore(store ARQStore) SessionOption {
+	return func(o *sessionOptions) {
+		o.arqStore = store
+	}
+}
+
+// WithOutgoingFilter adds a filter to the pipeline applied, in the order
+// added, to the content of every message sent except by SendRawForward.
+func WithOutgoingFilter(filter OutgoingFilter) SessionOption {
+	return func(o *sessionOptions) {
+		o.outgoingFilters = append(o.outgoingFilters, filter)
+	}
+}
+
+// WithDeliveryProofs makes the Session retain the transcript of each
+// SURB reply acknowledging a message, and pass it to fn.  By default no
+// transcripts are retained and SURB keys are zeroed once used.
+func WithDeliveryProofs(fn DeliveryProofHandler) SessionOption {
+	return func(o *sessionOptions) {
+		o.proofHandler = fn
+	}
+}
+
+// withMessageLimit bounds the number of messages the Session will send.
+func withMessageLimit(maxMessages uint64) SessionOption {
+	return func(o *sessionOptions) {
+		o.maxMessages = maxMessages
+	}
+}
+
+// withEventLog records the Session's events in the given event log.
+func withEventLog(l *eventlog.Log) SessionOption {
+	return func(o *sessionOptions) {
+		o.eventLog = l
+	}
+}
+
+// WithScheduleRand makes the Session draw the Poisson intervals of its
+// send schedule from rng instead of a generator seeded from the system
+// entropy source.  With a seeded rng and a ManualClock the schedule is
+// reproducible, which is useful for tests but must never be used
+// otherwise, as the schedule must not be predictable.
+func WithScheduleRand(rng *mrand.Rand) SessionOption {
+	return func(o *sessionOptions) {
+		o.scheduleRng = rng
+	}
+}
@@ -0,0 +1,202 @@
+// options_test.go - mixnet client session option tests
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/client/config"
+	cConstants "github.com/katzenpost/client/constants"
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/log"
+	"github.com/katzenpost/core/pki"
+	sConstants "github.com/katzenpost/core/sphinx/constants"
+	"github.com/katzenpost/minclient"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/eapache/channels.v1"
+)
+
+// fakeMinclient is a Minclient which records what is sent instead of
+// sending it.
+type fakeMinclient struct {
+	sync.Mutex
+
+	doc          *pki.Document
+	sendErr      error
+	onSend       func()
+	onFetch      func()
+	sent         []string
+	pollInterval time.Duration
+	fetches      int
+	eta          time.Duration
+}
+
+func (m *fakeMinclient) send(recipient string) error {
+	if m.onSend != nil {
+		m.onSend()
+	}
+	m.Lock()
+	defer m.Unlock()
+	if m.sendErr != nil {
+		return m.sendErr
+	}
+	m.sent = append(m.sent, recipient)
+	return nil
+}
+
+func (m *fakeMinclient) SendCiphertext(recipient, provider string, surbID *[sConstants.SURBIDLength]byte, b []byte) ([]byte, time.Duration, error) {
+	if err := m.send(recipient); err != nil {
+		return nil, 0, err
+	}
+	if m.eta != 0 {
+		return []byte("key"), m.eta, nil
+	}
+	return []byte("key"), time.Second, nil
+}
+
+func (m *fakeMinclient) SendUnreliableCiphertext(recipient, provider string, b []byte) error {
+	return m.send(recipient)
+}
+
+func (m *fakeMinclient) CurrentDocument() *pki.Document {
+	m.Lock()
+	defer m.Unlock()
+	return m.doc
+}
+
+func (m *fakeMinclient) ClockSkew() time.Duration {
+	return 0
+}
+
+func (m *fakeMinclient) ForceFetch() {
+	m.Lock()
+	m.fetches++
+	m.Unlock()
+	if m.onFetch != nil {
+		m.onFetch()
+	}
+}
+
+func (m *fakeMinclient) SetPollInterval(interval time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.pollInterval = interval
+}
+
+func (m *fakeMinclient) Shutdown() {}
+
+func (m *fakeMinclient) Wait() {}
+
+func (m *fakeMinclient) sentTo() []string {
+	m.Lock()
+	defer m.Unlock()
+	return append([]string{}, m.sent...)
+}
+
+// testSession returns a Session using the given clock and Minclient,
+// without connecting to a Provider.  The caller must Halt it.
+func testSession(t *testing.T, clock *ManualClock, mc Minclient) *Session {
+	logBackend, err := log.New("", "ERROR", true)
+	require.NoError(t, err)
+	linkKey, err := ecdh.NewKeypair(rand.Reader)
+	require.NoError(t, err)
+	cfg := &config.Config{
+		Account: &config.Account{User: "alice", Provider: "acme"},
+		Debug: &config.Debug{
+			RetransmitBackoff:  2,
+			MaxRetransmitDelay: 3600,
+		},
+	}
+	s := &Session{
+		cfg:            cfg,
+		log:            logBackend.GetLogger("test"),
+		clock:          clock,
+		linkKey:        linkKey,
+		minclient:      mc,
+		createdAt:      clock.Now(),
+		fatalErrCh:     make(chan error, 1),
+		eventCh:        channels.NewInfiniteChannel(),
+		EventSink:      make(chan Event),
+		opCh:           make(chan workerOp, 8),
+		surbIDMap:      newSURBMap(cConstants.MaxPendingReplies),
+		rtt:            newRTTEstimator(),
+		serviceStats:   newServiceStatsTracker(),
+		decoyPayloadFn: zeroDecoyPayload,
+		decoyServiceFn: randomDecoyService,
+		docPolicies:    []DocPolicy{loopixDocPolicy},
+	}
+	s.egressQueue = NewQueue(0, RejectNewest, s.onEvicted)
+	s.rescheduler = NewRescheduler(s)
+	return s
+}
+
+func haltTestSession(s *Session) {
+	s.Halt()
+	s.rescheduler.timerQ.Halt()
+}
+
+func TestSessionOptions(t *testing.T) {
+	require := require.New(t)
+
+	o := newSessionOptions(nil)
+	require.NotNil(o.minclientFactory)
+	require.Equal(systemClock{}, o.clock)
+	require.NotNil(o.decoyPayloadFn)
+	require.NotNil(o.decoyServiceFn)
+	require.Nil(o.egressQueue)
+	require.Zero(o.dedupWindow)
+
+	mc := new(fakeMinclient)
+	clock := NewManualClock(time.Unix(0, 0))
+	q := NewQueue(1, EvictOldest, nil)
+	filter := OutgoingFilterFunc(func(recipient, provider string, message []byte) ([]byte, error) {
+		return message, nil
+	})
+	o = newSessionOptions([]SessionOption{
+		WithMinclientFactory(func(*minclient.ClientConfig) (Minclient, error) {
+			return mc, nil
+		}),
+		WithClock(clock),
+		WithEgressQueue(q),
+		WithDedupWindow(time.Minute),
+		WithPKISeed([]byte("seed")),
+		WithOutgoingFilter(filter),
+		WithOutgoingFilter(filter),
+	})
+	c, err := o.minclientFactory(nil)
+	require.NoError(err)
+	require.True(c == Minclient(mc))
+	require.True(o.clock == Clock(clock))
+	require.True(o.egressQueue == EgressQueue(q))
+	require.Equal(time.Minute, o.dedupWindow)
+	require.Equal([]byte("seed"), o.pkiSeed)
+	require.Len(o.outgoingFilters, 2)
+
+	// A factory error is returned as is.
+	factoryErr := errors.New("no transport")
+	o = newSessionOptions([]SessionOption{
+		WithMinclientFactory(func(*minclient.ClientConfig) (Minclient, error) {
+			return nil, factoryErr
+		}),
+	})
+	_, err = o.minclientFactory(nil)
+	require.Equal(factoryErr, err)
+}
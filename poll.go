@@ -0,0 +1,108 @@
+// poll.go - adaptive Provider polling
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/katzenpost/core/pki"
+)
+
+// pollAdjustInterval is how often the adaptive polling interval is
+// re-evaluated.
+const pollAdjustInterval = 30 * time.Second
+
+func (s *Session) isAdaptivePolling() bool {
+	return s.cfg.Debug.MaxPollingInterval != 0
+}
+
+func (s *Session) pollBounds() (time.Duration, time.Duration) {
+	return time.Duration(s.cfg.Debug.MinPollingInterval) * time.Millisecond,
+		time.Duration(s.cfg.Debug.MaxPollingInterval) * time.Millisecond
+}
+
+func clampDuration(d, min, max time.Duration) time.Duration {
+	if d < min {
+		return min
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+func (s *Session) setPollIntervalFromDoc(doc *pki.Document) {
+	slopFactor := 0.8
+	pollProviderMsec := time.Duration((1.0 / (doc.LambdaP + doc.LambdaL)) * slopFactor * float64(time.Millisecond))
+	if s.isAdaptivePolling() {
+		min, max := s.pollBounds()
+		pollProviderMsec = clampDuration(pollProviderMsec, min, max)
+	}
+	s.log.Debugf("onDocument(): setting PollInterval to %s", pollProviderMsec)
+	s.setPollInterval(pollProviderMsec)
+}
+
+func (s *Session) setPollInterval(interval time.Duration) {
+	s.pollLock.Lock()
+	defer s.pollLock.Unlock()
+	s.pollInterval = interval
+	s.minclient.SetPollInterval(interval)
+}
+
+// adjustPollInterval polls twice as often while messages are arriving or
+// replies are expected, and half as often otherwise, within the bounds.
+func (s *Session) adjustPollInterval(active bool) {
+	s.pollLock.Lock()
+	defer s.pollLock.Unlock()
+	if s.pollInterval == 0 || s.isDormant() {
+		return
+	}
+	next := s.pollInterval * 2
+	if active {
+		next = s.pollInterval / 2
+	}
+	min, max := s.pollBounds()
+	next = clampDuration(next, min, max)
+	if next <= 0 {
+		// Never poll the Provider in a tight loop.
+		return
+	}
+	if next != s.pollInterval {
+		s.log.Debugf("Adjusting PollInterval to %s", next)
+		s.pollInterval = next
+		s.minclient.SetPollInterval(next)
+	}
+}
+
+func (s *Session) pollIntervalWorker() {
+	timer := s.clock.NewTimer(pollAdjustInterval)
+	defer timer.Stop()
+	lastReceived := atomic.LoadUint64(&s.receivedCount)
+	for {
+		select {
+		case <-s.HaltCh():
+			s.log.Debugf("Poll interval worker terminating gracefully.")
+			return
+		case <-timer.C():
+			timer.Reset(pollAdjustInterval)
+		}
+		received := atomic.LoadUint64(&s.receivedCount)
+		s.adjustPollInterval(received != lastReceived || s.pendingUserReplies() != 0)
+		lastReceived = received
+	}
+}
@@ -0,0 +1,74 @@
+// poll_test.go - adaptive Provider polling tests
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClampDuration(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(time.Second, clampDuration(time.Millisecond, time.Second, time.Minute))
+	require.Equal(time.Minute, clampDuration(time.Hour, time.Second, time.Minute))
+	require.Equal(2*time.Second, clampDuration(2*time.Second, time.Second, time.Minute))
+}
+
+func TestAdjustPollInterval(t *testing.T) {
+	require := require.New(t)
+
+	mc := new(fakeMinclient)
+	s := testSession(t, NewManualClock(epoch0), mc)
+	defer haltTestSession(s)
+	s.cfg.Debug.MinPollingInterval = 100
+	s.cfg.Debug.MaxPollingInterval = 1000
+	require.True(s.isAdaptivePolling())
+
+	// The interval is unset until the first document.
+	s.adjustPollInterval(true)
+	require.Zero(mc.pollInterval)
+
+	s.setPollInterval(400 * time.Millisecond)
+	s.adjustPollInterval(true)
+	require.Equal(200*time.Millisecond, mc.pollInterval)
+
+	// Sustained activity halves the interval down to the minimum only.
+	for i := 0; i < 16; i++ {
+		s.adjustPollInterval(true)
+	}
+	require.Equal(100*time.Millisecond, mc.pollInterval)
+	require.Equal(100*time.Millisecond, s.pollInterval)
+
+	// And idleness doubles it up to the maximum.
+	s.adjustPollInterval(false)
+	require.Equal(200*time.Millisecond, mc.pollInterval)
+	for i := 0; i < 16; i++ {
+		s.adjustPollInterval(false)
+	}
+	require.Equal(time.Second, mc.pollInterval)
+
+	// A zero minimum never reaches a zero interval.
+	s.cfg.Debug.MinPollingInterval = 0
+	for i := 0; i < 64; i++ {
+		s.adjustPollInterval(true)
+	}
+	require.True(s.pollInterval > 0)
+	require.True(mc.pollInterval > 0)
+}
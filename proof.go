@@ -0,0 +1,104 @@
+// proof.go - SURB-ACK delivery proofs.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"errors"
+	"time"
+
+	cConstants "github.com/katzenpost/client/constants"
+	coreConstants "github.com/katzenpost/core/constants"
+	"github.com/katzenpost/core/sphinx"
+	sConstants "github.com/katzenpost/core/sphinx/constants"
+	"github.com/katzenpost/core/utils"
+)
+
+// ErrInvalidDeliveryProof is the error returned when a DeliveryProof's
+// reply does not decrypt with its key.
+var ErrInvalidDeliveryProof = errors.New("delivery proof reply does not decrypt with its key")
+
+// DeliveryProof is the transcript of the SURB reply which acknowledged a
+// message, retained so that its delivery to the recipient's Provider can
+// be re-verified later.  It shows that the reply was made with the SURB
+// sent with the message, which only the path to the recipient could
+// have used, but anyone holding the key could have produced it, so it is
+// evidence for the holder rather than a proof to third parties.
+//
+// The Key allows the reply to be decrypted, and must be protected like
+// the message itself.
+type DeliveryProof struct {
+	// MessageID is the acknowledged message's identifier.
+	MessageID [cConstants.MessageIDLength]byte
+
+	// SURBID is the identifier of the SURB the reply was made with.
+	SURBID [sConstants.SURBIDLength]byte
+
+	// Recipient and Provider are the message's destination.
+	Recipient string
+	Provider  string
+
+	// SentAt and ReceivedAt are when the message was sent and when its
+	// reply was received.
+	SentAt     time.Time
+	ReceivedAt time.Time
+
+	// Ciphertext is the SURB reply as received.
+	Ciphertext []byte
+
+	// Key is the SURB decryption key.
+	Key []byte
+}
+
+// Verify checks that the reply decrypts with the key, and returns the
+// reply payload.
+func (p *DeliveryProof) Verify() ([]byte, error) {
+	plaintext, err := sphinx.DecryptSURBPayload(p.Ciphertext, p.Key)
+	if err != nil || len(plaintext) != coreConstants.ForwardPayloadLength {
+		return nil, ErrInvalidDeliveryProof
+	}
+	return plaintext[2:], nil
+}
+
+// Wipe zeroes the key material of the proof.
+func (p *DeliveryProof) Wipe() {
+	utils.ExplicitBzero(p.Key)
+	p.Key = nil
+}
+
+// DeliveryProofHandler is called with the DeliveryProof of each message
+// acknowledged by a SURB reply.  It is called from the network receive
+// path and must not block.
+type DeliveryProofHandler func(*DeliveryProof)
+
+// retainDeliveryProof passes the transcript of msg's reply to the
+// DeliveryProofHandler, if any.  It must be called before the message's
+// key is wiped.
+func (s *Session) retainDeliveryProof(msg *Message, ciphertext []byte) {
+	if s.proofHandler == nil || msg.IsDecoy {
+		return
+	}
+	s.proofHandler(&DeliveryProof{
+		MessageID:  *msg.ID,
+		SURBID:     *msg.SURBID,
+		Recipient:  msg.Recipient,
+		Provider:   msg.Provider,
+		SentAt:     msg.SentAt,
+		ReceivedAt: s.clock.Now(),
+		Ciphertext: append([]byte{}, ciphertext...),
+		Key:        append([]byte{}, msg.Key...),
+	})
+}
@@ -0,0 +1,90 @@
+// proof_test.go - SURB-ACK delivery proof tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/katzenpost/core/constants"
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/sphinx"
+	"github.com/katzenpost/core/sphinx/commands"
+	"github.com/stretchr/testify/require"
+)
+
+// surbReply makes a SURB, replies to it with payload and routes the reply
+// over its path, returning the reply as received and the SURB's key.
+func surbReply(t *testing.T, payload []byte) ([]byte, []byte) {
+	require := require.New(t)
+
+	const nrHops = 3
+	keys := make([]*ecdh.PrivateKey, nrHops)
+	path := make([]*sphinx.PathHop, nrHops)
+	for i := range path {
+		var err error
+		keys[i], err = ecdh.NewKeypair(rand.Reader)
+		require.NoError(err)
+		path[i] = &sphinx.PathHop{PublicKey: keys[i].PublicKey()}
+		_, err = rand.Reader.Read(path[i].ID[:])
+		require.NoError(err)
+		if i < nrHops-1 {
+			path[i].Commands = append(path[i].Commands, &commands.NodeDelay{Delay: 1})
+			continue
+		}
+		recipient, surbReply := new(commands.Recipient), new(commands.SURBReply)
+		_, err = rand.Reader.Read(surbReply.ID[:])
+		require.NoError(err)
+		path[i].Commands = append(path[i].Commands, recipient, surbReply)
+	}
+	surb, key, err := sphinx.NewSURB(rand.Reader, path)
+	require.NoError(err)
+	pkt, _, err := sphinx.NewPacketFromSURB(surb, payload)
+	require.NoError(err)
+	var ciphertext []byte
+	for _, k := range keys {
+		ciphertext, _, _, err = sphinx.Unwrap(k, pkt)
+		require.NoError(err)
+	}
+	return ciphertext, key
+}
+
+func TestDeliveryProof(t *testing.T) {
+	require := require.New(t)
+
+	payload := make([]byte, constants.ForwardPayloadLength)
+	copy(payload[2:], "reply")
+	ciphertext, key := surbReply(t, payload)
+	proof := &DeliveryProof{Ciphertext: ciphertext, Key: key}
+	reply, err := proof.Verify()
+	require.NoError(err)
+	require.Equal(payload[2:], reply)
+
+	// A tampered reply or another SURB's key is rejected.
+	tampered := append([]byte{}, ciphertext...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = (&DeliveryProof{Ciphertext: tampered, Key: key}).Verify()
+	require.Equal(ErrInvalidDeliveryProof, err)
+	_, otherKey := surbReply(t, payload)
+	_, err = (&DeliveryProof{Ciphertext: ciphertext, Key: otherKey}).Verify()
+	require.Equal(ErrInvalidDeliveryProof, err)
+
+	proof.Wipe()
+	require.Nil(proof.Key)
+	_, err = proof.Verify()
+	require.Equal(ErrInvalidDeliveryProof, err)
+}
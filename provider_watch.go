@@ -0,0 +1,91 @@
+// provider_watch.go - Account Provider monitoring.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"bytes"
+
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/pki"
+)
+
+// providerMissingEpochs is the number of consecutive epochs the account's
+// Provider may be absent from the PKI document before it is reported.
+const providerMissingEpochs = 2
+
+// providerWatch tracks the account's Provider across PKI documents.  The
+// Session's providerWatch is only used by the worker.
+type providerWatch struct {
+	lastEpoch uint64
+	missing   int
+}
+
+// observe checks the account's Provider in a new PKI document, returning
+// an Event if it has been missing for several epochs, its identity key no
+// longer matches the pinned key, or it has not published mix keys for the
+// next epoch.  Each epoch is only checked once.
+func (w *providerWatch) observe(doc *pki.Document, provider string, pin *eddsa.PublicKey) Event {
+	if doc.Epoch == w.lastEpoch {
+		return nil
+	}
+	w.lastEpoch = doc.Epoch
+
+	var desc *pki.MixDescriptor
+	for _, p := range doc.Providers {
+		if p.Name == provider {
+			desc = p
+			break
+		}
+	}
+	if desc == nil {
+		w.missing++
+		if w.missing < providerMissingEpochs {
+			return nil
+		}
+		return &ProviderMissingEvent{
+			Provider: provider,
+			Epochs:   w.missing,
+		}
+	}
+	w.missing = 0
+
+	reason := ""
+	switch {
+	case pin != nil && !bytes.Equal(pin.Bytes(), desc.IdentityKey.Bytes()):
+		reason = "identity key does not match ProviderKeyPin"
+	case desc.MixKeys[doc.Epoch+1] == nil:
+		reason = "no mix key for the next epoch"
+	default:
+		return nil
+	}
+	return &ProviderKeyWarningEvent{
+		Provider: provider,
+		Epoch:    doc.Epoch,
+		Reason:   reason,
+	}
+}
+
+// checkAccountProvider reports problems with the account's Provider
+// found in a new PKI document.
+func (s *Session) checkAccountProvider(doc *pki.Document) {
+	e := s.providerWatch.observe(doc, s.cfg.Account.Provider, s.cfg.Account.ProviderKeyPin)
+	if e == nil {
+		return
+	}
+	s.log.Warningf("%v", e)
+	s.eventCh.In() <- e
+}
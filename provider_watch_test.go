@@ -0,0 +1,75 @@
+// provider_watch_test.go - Account Provider monitoring tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/pki"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviderWatch(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	identityKey, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	mixKey, err := ecdh.NewKeypair(rand.Reader)
+	require.NoError(err)
+
+	docFor := func(epoch uint64, withProvider bool) *pki.Document {
+		doc := testDoc(true)
+		doc.Epoch = epoch
+		if withProvider {
+			doc.Providers[0].IdentityKey = identityKey.PublicKey()
+			doc.Providers[0].MixKeys = map[uint64]*ecdh.PublicKey{
+				epoch:     mixKey.PublicKey(),
+				epoch + 1: mixKey.PublicKey(),
+			}
+		}
+		return doc
+	}
+
+	w := &providerWatch{}
+	assert.Nil(w.observe(docFor(1, true), "a", identityKey.PublicKey()))
+
+	// A missing Provider is only reported after several epochs.
+	assert.Nil(w.observe(docFor(2, false), "x", nil))
+	e := w.observe(docFor(3, false), "x", nil)
+	require.IsType(&ProviderMissingEvent{}, e)
+	assert.Equal(providerMissingEpochs, e.(*ProviderMissingEvent).Epochs)
+
+	// Each epoch is only checked once.
+	assert.Nil(w.observe(docFor(3, false), "x", nil))
+
+	otherKey, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	e = w.observe(docFor(4, true), "a", otherKey.PublicKey())
+	require.IsType(&ProviderKeyWarningEvent{}, e)
+	assert.Contains(e.(*ProviderKeyWarningEvent).Reason, "ProviderKeyPin")
+
+	doc := docFor(5, true)
+	delete(doc.Providers[0].MixKeys, 6)
+	e = w.observe(doc, "a", nil)
+	require.IsType(&ProviderKeyWarningEvent{}, e)
+	assert.Contains(e.(*ProviderKeyWarningEvent).Reason, "next epoch")
+}
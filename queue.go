@@ -29,6 +29,10 @@ var ErrQueueFull = errors.New("queue is full")
 // ErrQueueEmpty is the error issued when the queue is empty.
 var ErrQueueEmpty = errors.New("queue is empty")
 
+// ErrMessageEvicted is the error issued when a message is dropped from
+// a full queue to make room for a newer one.
+var ErrMessageEvicted = errors.New("message evicted from full queue")
+
 // EgressQueue is the egress queue interface.
 type EgressQueue interface {
 
@@ -38,18 +42,85 @@ type EgressQueue interface {
 	// Pop pops the next item off the queue.
 	Pop() (Item, error)
 
-	// Push pushes the item onto the queue.
+	// Push pushes the item onto the queue.  It may be called while the
+	// item returned by Peek is being sent, and must not drop that item.
 	Push(Item) error
 }
 
+// egressQueueLen returns the number of items in the queue if it has a
+// Len method, or else 1 if it holds any item.
+func egressQueueLen(q EgressQueue) int {
+	if l, ok := q.(interface{ Len() int }); ok {
+		return l.Len()
+	}
+	if _, err := q.Peek(); err == nil {
+		return 1
+	}
+	return 0
+}
+
+// EvictionPolicy selects what a full Queue does when an item is pushed.
+type EvictionPolicy int
+
+const (
+	// RejectNewest makes Push fail with ErrQueueFull when the queue is
+	// full, applying backpressure to the caller.
+	RejectNewest EvictionPolicy = iota
+
+	// EvictOldest makes Push drop the oldest item of a full queue behind
+	// its head to make room for the new item.  The head is never dropped,
+	// as it may be being sent, so a full queue of one item rejects pushes.
+	EvictOldest
+)
+
+// QueueMetrics are the counters of a Queue.
+type QueueMetrics struct {
+	// Pushed is the number of items pushed onto the queue.
+	Pushed uint64
+
+	// Popped is the number of items popped off the queue.
+	Popped uint64
+
+	// Rejected is the number of pushes which failed with ErrQueueFull.
+	Rejected uint64
+
+	// Evicted is the number of items dropped to make room for others.
+	Evicted uint64
+}
+
 // Queue is our in-memory queue implementation used as our egress FIFO queue
-// for messages sent by the client.
+// for messages sent by the client.  The zero value is an empty queue of
+// constants.MaxEgressQueueSize items which rejects pushes when full.
 type Queue struct {
 	sync.Mutex
-	content   [constants.MaxEgressQueueSize]Item
+	content   []Item
 	readHead  int
 	writeHead int
 	len       int
+
+	policy  EvictionPolicy
+	onEvict func(Item)
+	metrics QueueMetrics
+}
+
+// NewQueue returns a new Queue holding at most capacity items, which
+// applies the given policy when full.  If onEvict is not nil it is
+// called with each item evicted, with the queue lock held.
+func NewQueue(capacity int, policy EvictionPolicy, onEvict func(Item)) *Queue {
+	if capacity <= 0 {
+		capacity = constants.MaxEgressQueueSize
+	}
+	return &Queue{
+		content: make([]Item, capacity),
+		policy:  policy,
+		onEvict: onEvict,
+	}
+}
+
+func (q *Queue) init() {
+	if q.content == nil {
+		q.content = make([]Item, constants.MaxEgressQueueSize)
+	}
 }
 
 // Push pushes the given message ref onto the queue and returns nil
@@ -57,12 +128,22 @@ type Queue struct {
 func (q *Queue) Push(e Item) error {
 	q.Lock()
 	defer q.Unlock()
-	if q.len >= constants.MaxEgressQueueSize {
-		return ErrQueueFull
+	q.init()
+	if q.len >= len(q.content) {
+		if q.policy != EvictOldest || q.len < 2 {
+			q.metrics.Rejected++
+			return ErrQueueFull
+		}
+		evicted := q.evict()
+		q.metrics.Evicted++
+		if q.onEvict != nil {
+			q.onEvict(evicted)
+		}
 	}
 	q.content[q.writeHead] = e
-	q.writeHead = (q.writeHead + 1) % constants.MaxEgressQueueSize
+	q.writeHead = (q.writeHead + 1) % len(q.content)
 	q.len++
+	q.metrics.Pushed++
 	return nil
 }
 
@@ -74,11 +155,27 @@ func (q *Queue) Pop() (Item, error) {
 	if q.len <= 0 {
 		return nil, ErrQueueEmpty
 	}
+	q.metrics.Popped++
+	return q.pop(), nil
+}
+
+func (q *Queue) pop() Item {
 	result := q.content[q.readHead]
-	q.content[q.readHead] = &Message{}
-	q.readHead = (q.readHead + 1) % constants.MaxEgressQueueSize
+	q.content[q.readHead] = nil
+	q.readHead = (q.readHead + 1) % len(q.content)
 	q.len--
-	return result, nil
+	return result
+}
+
+// evict removes the item after the head, moving the head into its place.
+func (q *Queue) evict() Item {
+	next := (q.readHead + 1) % len(q.content)
+	result := q.content[next]
+	q.content[next] = q.content[q.readHead]
+	q.content[q.readHead] = nil
+	q.readHead = next
+	q.len--
+	return result
 }
 
 // Peek returns the next message ref from the queue without
@@ -92,3 +189,25 @@ func (q *Queue) Peek() (Item, error) {
 	result := q.content[q.readHead]
 	return result, nil
 }
+
+// Len returns the number of message refs in the queue.
+func (q *Queue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return q.len
+}
+
+// Cap returns the maximum number of message refs the queue holds.
+func (q *Queue) Cap() int {
+	q.Lock()
+	defer q.Unlock()
+	q.init()
+	return len(q.content)
+}
+
+// Metrics returns the queue's counters.
+func (q *Queue) Metrics() QueueMetrics {
+	q.Lock()
+	defer q.Unlock()
+	return q.metrics
+}
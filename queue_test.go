@@ -39,3 +39,81 @@ func TestQueue(t *testing.T) {
 	_, err = q.Pop()
 	assert.Error(err)
 }
+
+func TestQueueCapacity(t *testing.T) {
+	assert := assert.New(t)
+
+	q := NewQueue(3, RejectNewest, nil)
+	assert.Equal(3, q.Cap())
+	for i := 0; i < 3; i++ {
+		assert.NoError(q.Push(foo{"hello"}))
+	}
+	assert.Equal(ErrQueueFull, q.Push(foo{"hello"}))
+	assert.Equal(3, q.Len())
+
+	metrics := q.Metrics()
+	assert.Equal(uint64(3), metrics.Pushed)
+	assert.Equal(uint64(1), metrics.Rejected)
+	assert.Equal(uint64(0), metrics.Evicted)
+
+	assert.Equal(constants.MaxEgressQueueSize, new(Queue).Cap())
+	assert.Equal(constants.MaxEgressQueueSize, NewQueue(0, RejectNewest, nil).Cap())
+}
+
+func TestQueueEvictOldest(t *testing.T) {
+	assert := assert.New(t)
+
+	evicted := []Item{}
+	q := NewQueue(2, EvictOldest, func(i Item) {
+		evicted = append(evicted, i)
+	})
+	assert.NoError(q.Push(foo{"a"}))
+	assert.NoError(q.Push(foo{"b"}))
+	assert.NoError(q.Push(foo{"c"}))
+	assert.NoError(q.Push(foo{"d"}))
+	assert.Equal(2, q.Len())
+
+	// The head may be being sent, so the oldest item behind it goes.
+	assert.Equal([]Item{foo{"b"}, foo{"c"}}, evicted)
+	s, err := q.Pop()
+	assert.NoError(err)
+	assert.Equal("a", s.(foo).x)
+	s, err = q.Pop()
+	assert.NoError(err)
+	assert.Equal("d", s.(foo).x)
+	_, err = q.Pop()
+	assert.Equal(ErrQueueEmpty, err)
+
+	metrics := q.Metrics()
+	assert.Equal(uint64(4), metrics.Pushed)
+	assert.Equal(uint64(2), metrics.Popped)
+	assert.Equal(uint64(2), metrics.Evicted)
+
+	// A full queue of one item only holds the head.
+	q = NewQueue(1, EvictOldest, nil)
+	assert.NoError(q.Push(foo{"a"}))
+	assert.Equal(ErrQueueFull, q.Push(foo{"b"}))
+	s, err = q.Peek()
+	assert.NoError(err)
+	assert.Equal("a", s.(foo).x)
+}
+
+// lenlessQueue is an EgressQueue without a Len method.
+type lenlessQueue struct {
+	q Queue
+}
+
+func (l *lenlessQueue) Peek() (Item, error) { return l.q.Peek() }
+func (l *lenlessQueue) Pop() (Item, error)  { return l.q.Pop() }
+func (l *lenlessQueue) Push(i Item) error   { return l.q.Push(i) }
+
+func TestEgressQueueLen(t *testing.T) {
+	assert := assert.New(t)
+
+	q := new(lenlessQueue)
+	assert.Zero(egressQueueLen(q))
+	assert.NoError(q.Push(foo{"hello"}))
+	assert.NoError(q.Push(foo{"world"}))
+	assert.Equal(1, egressQueueLen(q))
+	assert.Equal(2, egressQueueLen(&q.q))
+}
@@ -0,0 +1,86 @@
+// registration_test.go - account registration tests
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/katzenpost/client/config"
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/crypto/rand"
+	registration "github.com/katzenpost/registration_client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistrationOptions(t *testing.T) {
+	require := require.New(t)
+
+	// An unvalidated configuration has no upstream proxy.
+	opts := registrationOptions(new(config.Config), "https")
+	require.Equal("https", opts.Scheme)
+	require.False(opts.UseSocks)
+
+	authorityKey, err := eddsa.NewKeypair(rand.Reader)
+	require.NoError(err)
+	cfg := &config.Config{
+		UpstreamProxy: &config.UpstreamProxy{
+			Type:    "socks5",
+			Network: "tcp",
+			Address: "127.0.0.1:9050",
+		},
+		NonvotingAuthority: &config.NonvotingAuthority{
+			Address:   "127.0.0.1:29483",
+			PublicKey: authorityKey.PublicKey(),
+		},
+	}
+	require.NoError(cfg.FixupAndMinimallyValidate())
+	opts = registrationOptions(cfg, "http")
+	require.True(opts.UseSocks)
+	require.Equal("tcp", opts.SocksNetwork)
+	require.Equal("127.0.0.1:9050", opts.SocksAddress)
+}
+
+func TestCheckRegistrationProxy(t *testing.T) {
+	require := require.New(t)
+
+	direct := registrationOptions(new(config.Config), "http")
+	proxied := &registration.Options{
+		UseSocks:     true,
+		SocksNetwork: "tcp",
+		SocksAddress: "127.0.0.1:9050",
+	}
+	require.NoError(checkRegistrationProxy(nil, direct))
+	require.NoError(checkRegistrationProxy(&registration.Options{Scheme: "https"}, direct))
+
+	// A requested proxy is never silently replaced by a direct connection.
+	requested := &registration.Options{UseSocks: true}
+	require.Equal(ErrRegistrationProxy, checkRegistrationProxy(requested, direct))
+	require.NoError(checkRegistrationProxy(requested, proxied))
+	requested.SocksAddress = "127.0.0.1:9150"
+	require.Equal(ErrRegistrationProxy, checkRegistrationProxy(requested, proxied))
+	requested.SocksAddress = proxied.SocksAddress
+	requested.SocksNetwork = "unix"
+	require.Equal(ErrRegistrationProxy, checkRegistrationProxy(requested, proxied))
+	requested.SocksNetwork = "tcp"
+	require.NoError(checkRegistrationProxy(requested, proxied))
+
+	cfg := &config.Config{
+		Account:      &config.Account{User: "alice", Provider: "acme"},
+		Registration: &config.Registration{Address: "127.0.0.1:36968", Options: &registration.Options{UseSocks: true}},
+	}
+	require.Equal(ErrRegistrationProxy, RegisterClient(cfg, nil))
+}
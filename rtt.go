@@ -0,0 +1,116 @@
+// rtt.go - round trip time estimation
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	rttAlpha       = 8 // SRTT gain is 1/rttAlpha.
+	rttBeta        = 4 // RTTVAR gain is 1/rttBeta.
+	rttGranularity = time.Second
+)
+
+type rttState struct {
+	srtt   time.Duration
+	rttvar time.Duration
+}
+
+// rttEstimator estimates the SURB reply round trip time to each
+// destination Provider from observed reply latencies, in the manner
+// of the TCP retransmission timer (RFC 6298).
+type rttEstimator struct {
+	sync.Mutex
+
+	providers map[string]*rttState
+}
+
+func newRTTEstimator() *rttEstimator {
+	return &rttEstimator{
+		providers: make(map[string]*rttState),
+	}
+}
+
+// Observe updates the estimate for the Provider with a measured round
+// trip time.
+func (e *rttEstimator) Observe(provider string, rtt time.Duration) {
+	e.Lock()
+	defer e.Unlock()
+	st, ok := e.providers[provider]
+	if !ok {
+		e.providers[provider] = &rttState{
+			srtt:   rtt,
+			rttvar: rtt / 2,
+		}
+		return
+	}
+	delta := st.srtt - rtt
+	if delta < 0 {
+		delta = -delta
+	}
+	st.rttvar += (delta - st.rttvar) / rttBeta
+	st.srtt += (rtt - st.srtt) / rttAlpha
+}
+
+// RTO returns the retransmission timeout for the Provider, which is
+// never less than lowerBound, the delay computed from the packet's path.
+func (e *rttEstimator) RTO(provider string, lowerBound time.Duration) time.Duration {
+	e.Lock()
+	defer e.Unlock()
+	st, ok := e.providers[provider]
+	if !ok {
+		return lowerBound
+	}
+	variance := 4 * st.rttvar
+	if variance < rttGranularity {
+		variance = rttGranularity
+	}
+	rto := st.srtt + variance
+	if rto < lowerBound {
+		return lowerBound
+	}
+	return rto
+}
+
+// SRTT returns the smoothed round trip time to the Provider and
+// true, or false if no round trip has been observed.
+func (e *rttEstimator) SRTT(provider string) (time.Duration, bool) {
+	e.Lock()
+	defer e.Unlock()
+	st, ok := e.providers[provider]
+	if !ok {
+		return 0, false
+	}
+	return st.srtt, true
+}
+
+// backoff returns the reply timeout of a message after the given number
+// of retransmissions, which grows from rto by factor with each
+// retransmission up to max, but is never less than rto.
+func backoff(rto time.Duration, retransmissions uint32, factor float64, max time.Duration) time.Duration {
+	timeout := float64(rto) * math.Pow(factor, float64(retransmissions))
+	if max > 0 && timeout > float64(max) {
+		timeout = float64(max)
+	}
+	if timeout < float64(rto) {
+		return rto
+	}
+	return time.Duration(timeout)
+}
@@ -0,0 +1,68 @@
+// rtt_test.go - round trip time estimation tests
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRTTEstimator(t *testing.T) {
+	assert := assert.New(t)
+
+	e := newRTTEstimator()
+	assert.Equal(5*time.Second, e.RTO("acme", 5*time.Second))
+	_, ok := e.SRTT("acme")
+	assert.False(ok)
+
+	// The first sample sets SRTT = R and RTTVAR = R/2.
+	e.Observe("acme", 10*time.Second)
+	srtt, ok := e.SRTT("acme")
+	assert.True(ok)
+	assert.Equal(10*time.Second, srtt)
+	assert.Equal(30*time.Second, e.RTO("acme", 5*time.Second))
+
+	// The path based lower bound always wins when it is larger.
+	assert.Equal(time.Minute, e.RTO("acme", time.Minute))
+
+	// A steady RTT shrinks the variance towards zero.
+	for i := 0; i < 100; i++ {
+		e.Observe("acme", 10*time.Second)
+	}
+	assert.Equal(10*time.Second+rttGranularity, e.RTO("acme", 0))
+
+	// Other Providers are tracked independently.
+	assert.Equal(time.Second, e.RTO("example", time.Second))
+}
+
+func TestBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	rto := 10 * time.Second
+	assert.Equal(rto, backoff(rto, 0, 2, time.Minute))
+	assert.Equal(20*time.Second, backoff(rto, 1, 2, time.Minute))
+	assert.Equal(40*time.Second, backoff(rto, 2, 2, time.Minute))
+	assert.Equal(time.Minute, backoff(rto, 3, 2, time.Minute))
+	assert.Equal(time.Minute, backoff(rto, 1000, 2, time.Minute))
+
+	// A factor of 1 disables the backoff, and the cap never lowers the
+	// timeout below the RTO.
+	assert.Equal(rto, backoff(rto, 5, 1, time.Minute))
+	assert.Equal(rto, backoff(rto, 5, 2, time.Second))
+}
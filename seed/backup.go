@@ -0,0 +1,157 @@
+// backup.go - Passphrase encrypted key backups.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package seed
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/katzenpost/core/utils"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	backupVersion = 1
+	saltSize      = 16
+	nonceSize     = 24
+	keySize       = 32
+
+	// Argon2id parameters, as recommended by RFC 9106 for memory
+	// constrained environments.
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+)
+
+var (
+	// ErrBackupVersion is the error issued when a backup was made by an
+	// unsupported version.
+	ErrBackupVersion = errors.New("seed: unsupported backup version")
+
+	// ErrBackupDecrypt is the error issued when a backup does not
+	// decrypt, because the passphrase is wrong or the backup is corrupt.
+	ErrBackupDecrypt = errors.New("seed: wrong passphrase or corrupt backup")
+)
+
+// Backup is the content of an encrypted key backup, which lets an
+// account's keys be restored on a new machine without copying its data
+// directory.
+type Backup struct {
+	// Seed is the master seed, if any.
+	Seed []byte
+
+	// LinkKeys are the link keys which are not derived from the Seed,
+	// by account.
+	LinkKeys map[string][]byte
+}
+
+// AddLinkKey adds the link key of the account to the backup.
+func (b *Backup) AddLinkKey(account string, linkKey *ecdh.PrivateKey) {
+	if b.LinkKeys == nil {
+		b.LinkKeys = make(map[string][]byte)
+	}
+	b.LinkKeys[account] = append([]byte{}, linkKey.Bytes()...)
+}
+
+// LinkKey returns the link key of the account, or nil if the backup has
+// none for it.
+func (b *Backup) LinkKey(account string) (*ecdh.PrivateKey, error) {
+	raw, ok := b.LinkKeys[account]
+	if !ok {
+		return nil, nil
+	}
+	linkKey := new(ecdh.PrivateKey)
+	if err := linkKey.FromBytes(raw); err != nil {
+		return nil, err
+	}
+	return linkKey, nil
+}
+
+// Wipe zeroes the key material of the backup.
+func (b *Backup) Wipe() {
+	utils.ExplicitBzero(b.Seed)
+	for _, raw := range b.LinkKeys {
+		utils.ExplicitBzero(raw)
+	}
+}
+
+// Encrypt returns the backup encrypted with a key derived from the
+// passphrase with Argon2id.  The result is the version, the salt, the
+// nonce and the secretbox sealed backup.
+func (b *Backup) Encrypt(passphrase []byte) ([]byte, error) {
+	if b.Seed != nil && len(b.Seed) != Size {
+		return nil, ErrInvalidSeed
+	}
+	plaintext, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.ExplicitBzero(plaintext)
+
+	header := make([]byte, 1+saltSize+nonceSize)
+	header[0] = backupVersion
+	if _, err := io.ReadFull(rand.Reader, header[1:]); err != nil {
+		return nil, err
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], header[1+saltSize:])
+	key := backupKey(passphrase, header[1:1+saltSize])
+	defer utils.ExplicitBzero(key[:])
+	return secretbox.Seal(header, plaintext, &nonce, key), nil
+}
+
+// DecryptBackup returns the backup encrypted by Backup.Encrypt with the
+// passphrase.
+func DecryptBackup(blob, passphrase []byte) (*Backup, error) {
+	if len(blob) < 1+saltSize+nonceSize+secretbox.Overhead {
+		return nil, ErrBackupDecrypt
+	}
+	if blob[0] != backupVersion {
+		return nil, ErrBackupVersion
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], blob[1+saltSize:])
+	key := backupKey(passphrase, blob[1:1+saltSize])
+	defer utils.ExplicitBzero(key[:])
+	plaintext, ok := secretbox.Open(nil, blob[1+saltSize+nonceSize:], &nonce, key)
+	if !ok {
+		return nil, ErrBackupDecrypt
+	}
+	defer utils.ExplicitBzero(plaintext)
+
+	b := new(Backup)
+	if err := json.Unmarshal(plaintext, b); err != nil {
+		return nil, err
+	}
+	if b.Seed != nil && len(b.Seed) != Size {
+		b.Wipe()
+		return nil, ErrInvalidSeed
+	}
+	return b, nil
+}
+
+func backupKey(passphrase, salt []byte) *[keySize]byte {
+	var key [keySize]byte
+	raw := argon2.IDKey(passphrase, salt, argonTime, argonMemory, argonThreads, keySize)
+	copy(key[:], raw)
+	utils.ExplicitBzero(raw)
+	return &key
+}
@@ -0,0 +1,74 @@
+// backup_test.go - Passphrase encrypted key backup tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package seed
+
+import (
+	"testing"
+
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/crypto/rand"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackup(t *testing.T) {
+	require := require.New(t)
+
+	seed, err := New()
+	require.NoError(err)
+	linkKey, err := ecdh.NewKeypair(rand.Reader)
+	require.NoError(err)
+	b := &Backup{Seed: seed}
+	b.AddLinkKey("alice@acme", linkKey)
+	passphrase := []byte("correct horse battery staple")
+	blob, err := b.Encrypt(passphrase)
+	require.NoError(err)
+
+	// The keys are restored with the passphrase.
+	restored, err := DecryptBackup(blob, passphrase)
+	require.NoError(err)
+	require.Equal(seed, restored.Seed)
+	restoredKey, err := restored.LinkKey("alice@acme")
+	require.NoError(err)
+	require.Equal(linkKey.Bytes(), restoredKey.Bytes())
+	missing, err := restored.LinkKey("bob@acme")
+	require.NoError(err)
+	require.Nil(missing)
+
+	// Each backup is encrypted with a new salt and nonce.
+	other, err := b.Encrypt(passphrase)
+	require.NoError(err)
+	require.NotEqual(blob, other)
+
+	_, err = DecryptBackup(blob, []byte("wrong"))
+	require.Equal(ErrBackupDecrypt, err)
+	tampered := append([]byte{}, blob...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = DecryptBackup(tampered, passphrase)
+	require.Equal(ErrBackupDecrypt, err)
+	_, err = DecryptBackup(blob[:10], passphrase)
+	require.Equal(ErrBackupDecrypt, err)
+	tampered = append([]byte{}, blob...)
+	tampered[0] = 2
+	_, err = DecryptBackup(tampered, passphrase)
+	require.Equal(ErrBackupVersion, err)
+
+	_, err = (&Backup{Seed: []byte("short")}).Encrypt(passphrase)
+	require.Equal(ErrInvalidSeed, err)
+
+	restored.Wipe()
+	require.Equal(make([]byte, Size), restored.Seed)
+}
@@ -0,0 +1,144 @@
+// seed.go - Deterministic key derivation from a master seed.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package seed derives account keys from a single master seed, so that
+// all of a user's keys can be backed up as one seed phrase.
+//
+// Keys are derived with HKDF-SHA256 (RFC 5869) from the seed, using a
+// path of the form "m/<purpose>/<account>" as the info parameter.  Keys
+// loaded from individual files remain usable alongside derived keys, and
+// can be kept with the seed in a passphrase encrypted Backup.
+package seed
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/crypto/rand"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// Size is the size of a master seed in bytes.
+	Size = 32
+
+	checksumSize = 2
+	groupSize    = 4
+	salt         = "katzenpost-client-seed-v1"
+	linkPurpose  = "link"
+)
+
+var (
+	// ErrInvalidSeed is the error issued when a seed is not Size bytes long.
+	ErrInvalidSeed = errors.New("seed: invalid seed size")
+
+	// ErrChecksum is the error issued when a seed phrase fails to verify.
+	ErrChecksum = errors.New("seed: seed phrase checksum mismatch")
+
+	encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// New returns a new random master seed.
+func New() ([]byte, error) {
+	seed := make([]byte, Size)
+	if _, err := io.ReadFull(rand.Reader, seed); err != nil {
+		return nil, err
+	}
+	return seed, nil
+}
+
+// Path returns the derivation path of the key for the given purpose
+// and account index.
+func Path(purpose string, account uint32) string {
+	return fmt.Sprintf("m/%s/%d", purpose, account)
+}
+
+// Derive returns size bytes of key material for the derivation path.
+func Derive(seed []byte, path string, size int) ([]byte, error) {
+	if len(seed) != Size {
+		return nil, ErrInvalidSeed
+	}
+	if size > 255*sha256.Size {
+		return nil, errors.New("seed: requested key material too long")
+	}
+
+	out := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, seed, []byte(salt), []byte(path)), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LinkKey derives the link key of the given account from the seed.
+func LinkKey(seed []byte, account uint32) (*ecdh.PrivateKey, error) {
+	raw, err := Derive(seed, Path(linkPurpose, account), ecdh.PrivateKeySize)
+	if err != nil {
+		return nil, err
+	}
+	linkKey := new(ecdh.PrivateKey)
+	if err := linkKey.FromBytes(raw); err != nil {
+		return nil, err
+	}
+	return linkKey, nil
+}
+
+// Encode returns the seed phrase of the seed, which is the seed and a
+// checksum in base32, split into groups of four characters.
+func Encode(seed []byte) (string, error) {
+	if len(seed) != Size {
+		return "", ErrInvalidSeed
+	}
+	digest := sha256.Sum256(seed)
+	s := encoding.EncodeToString(append(append([]byte{}, seed...), digest[:checksumSize]...))
+	groups := []string{}
+	for len(s) > groupSize {
+		groups = append(groups, s[:groupSize])
+		s = s[groupSize:]
+	}
+	groups = append(groups, s)
+	return strings.ToLower(strings.Join(groups, "-")), nil
+}
+
+// Decode returns the seed of a seed phrase produced by Encode.  Case,
+// whitespace and group separators are ignored.
+func Decode(phrase string) ([]byte, error) {
+	phrase = strings.Map(func(r rune) rune {
+		switch r {
+		case '-', ' ', '\t', '\n', '\r':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(phrase))
+	raw, err := encoding.DecodeString(phrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != Size+checksumSize {
+		return nil, ErrInvalidSeed
+	}
+	seed := raw[:Size]
+	digest := sha256.Sum256(seed)
+	if !hmac.Equal(digest[:checksumSize], raw[Size:]) {
+		return nil, ErrChecksum
+	}
+	return seed, nil
+}
@@ -0,0 +1,94 @@
+// seed_test.go - Deterministic key derivation tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package seed
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDerive(t *testing.T) {
+	require := require.New(t)
+
+	// The derivation must be stable across releases, or seeds would no
+	// longer restore the keys they were backed up for.
+	seed := make([]byte, Size)
+	out, err := Derive(seed, Path(linkPurpose, 0), 32)
+	require.NoError(err)
+	require.Equal("da85aa336c8ff88d56bfcd0c021495f902b02e51956580f0883f015fcd826123", hex.EncodeToString(out))
+
+	other, err := Derive(seed, Path(linkPurpose, 1), 32)
+	require.NoError(err)
+	require.NotEqual(out, other)
+
+	long, err := Derive(seed, Path(linkPurpose, 0), 80)
+	require.NoError(err)
+	require.Equal(out, long[:32])
+
+	_, err = Derive(seed[:16], Path(linkPurpose, 0), 32)
+	require.Equal(ErrInvalidSeed, err)
+}
+
+func TestLinkKey(t *testing.T) {
+	require := require.New(t)
+
+	seed, err := New()
+	require.NoError(err)
+	k0, err := LinkKey(seed, 0)
+	require.NoError(err)
+	k0Again, err := LinkKey(seed, 0)
+	require.NoError(err)
+	k1, err := LinkKey(seed, 1)
+	require.NoError(err)
+	require.Equal(k0.PublicKey().Bytes(), k0Again.PublicKey().Bytes())
+	require.NotEqual(k0.PublicKey().Bytes(), k1.PublicKey().Bytes())
+}
+
+func TestSeedPhrase(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	seed, err := hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	require.NoError(err)
+	phrase, err := Encode(seed)
+	require.NoError(err)
+	assert.Equal("aaaq-eaye-auda-ocaj-bifq-ydio-b4ib-ceqt-cqkr-mfyy-denb-wha5-dypw-gdi", phrase)
+
+	decoded, err := Decode(phrase)
+	require.NoError(err)
+	assert.Equal(seed, decoded)
+
+	decoded, err = Decode(" " + strings.ToUpper(strings.Replace(phrase, "-", " ", -1)) + "\n")
+	require.NoError(err)
+	assert.Equal(seed, decoded)
+
+	corrupt := []byte(phrase)
+	if corrupt[0] == 'a' {
+		corrupt[0] = 'b'
+	} else {
+		corrupt[0] = 'a'
+	}
+	_, err = Decode(string(corrupt))
+	assert.Equal(ErrChecksum, err)
+
+	_, err = Encode(seed[:31])
+	assert.Equal(ErrInvalidSeed, err)
+}
@@ -23,8 +23,10 @@ import (
 	"fmt"
 	"github.com/katzenpost/client/utils"
 	"io"
+	"sync/atomic"
 	"time"
 
+	"github.com/katzenpost/client/config"
 	cConstants "github.com/katzenpost/client/constants"
 	"github.com/katzenpost/core/constants"
 	"github.com/katzenpost/core/crypto/rand"
@@ -33,29 +35,79 @@ import (
 
 var ErrReplyTimeout = errors.New("failure waiting for reply, timeout reached")
 var ErrMessageNotSent = errors.New("failure sending message")
+var ErrMessageLimitReached = errors.New("session message limit reached")
+var ErrCheckMailCooldown = errors.New("mail was checked too recently")
+var ErrSessionClosing = errors.New("session is closing")
+var ErrSessionHalted = errors.New("session halted before closing")
+var ErrInvalidRawPayloadSize = errors.New("raw payload must be exactly UserForwardPayloadLength bytes")
+var ErrInvalidCopies = fmt.Errorf("copies must be between 1 and %d", config.MaxCopies)
+
+// RawSendOptions are the options of SendRawForward.
+type RawSendOptions struct {
+	// WithSURB attaches a SURB so that the recipient can reply.
+	WithSURB bool
+
+	// Reliable enables automatic retransmissions, and requires WithSURB.
+	Reliable bool
+
+	// Copies is the number of copies to send, as for SendRedundantMessage.
+	Copies int
+}
 
 func (s *Session) sendNext() {
 	msg, err := s.egressQueue.Peek()
 	if err != nil {
-		s.fatalErrCh <- errors.New("impossible failure to Peek from queue")
+		s.fatal(errors.New("impossible failure to Peek from queue"))
 		return
 	}
 	if msg == nil {
-		s.fatalErrCh <- errors.New("impossible failure, got nil message from queue")
+		s.fatal(errors.New("impossible failure, got nil message from queue"))
 		return
 	}
 	m := msg.(*Message)
 	s.doSend(m)
 	_, err = s.egressQueue.Pop()
 	if err != nil {
-		s.fatalErrCh <- errors.New("impossible failure to Pop from queue")
+		s.fatal(errors.New("impossible failure to Pop from queue"))
+	}
+}
+
+// onEvicted is called when a message is evicted from the egress queue.
+func (s *Session) onEvicted(i Item) {
+	msg := i.(*Message)
+	s.log.Warningf("Message %x evicted from the full egress queue", *msg.ID)
+	if msg.IsBlocking {
+		s.notifySent(msg, ErrMessageEvicted)
+		return
+	}
+	s.eventCh.In() <- &MessageSentEvent{
+		MessageID: msg.ID,
+		Err:       ErrMessageEvicted,
+	}
+}
+
+// notifySent tells a blocking sender whether its message was sent, by
+// passing it the message or nil.  Only the first notification is
+// delivered, later ones such as retransmissions are dropped, and the
+// channel is never closed so that notifying twice is harmless.
+func (s *Session) notifySent(msg *Message, err error) {
+	sentWaitChanRaw, ok := s.sentWaitChanMap.Load(*msg.ID)
+	if !ok {
+		return
+	}
+	if err != nil {
+		msg = nil
+	}
+	select {
+	case sentWaitChanRaw.(chan *Message) <- msg:
+	default:
 	}
 }
 
 func NewRescheduler(s *Session) *rescheduler {
 	r := &rescheduler{s: s}
 	s.log.Debugf("Creating TimerQueue")
-	r.timerQ = NewTimerQueue(r)
+	r.timerQ = newTimerQueue(r, s.clock)
 	return r
 }
 
@@ -68,14 +120,46 @@ func (r *rescheduler) Push(i Item) error {
 	// rescheduler checks whether a message was ACK'd when the timerQ fires
 	// and if it has not, reschedules the message for transmission again
 	m := i.(*Message)
-	if _, ok := r.s.surbIDMap.Load(*m.SURBID); ok {
-		// still waiting for a SURB-ACK that hasn't arrived
-		r.s.surbIDMap.Delete(*m.SURBID)
-		r.s.opCh <- opRetransmit{msg: m}
+	if _, ok := r.s.surbIDMap.LoadAndDelete(*m.SURBID, r.s.clock.Now()); ok {
+		// still waiting for a SURB-ACK that hasn't arrived,
+		// the retransmission will use a new SURB and key
+		m.wipeKey()
+		if m.group != nil && m.group.isReplied() {
+			// another copy was acknowledged
+			r.s.arqDelete(m)
+			m.wipe()
+			return nil
+		}
+		r.s.pushRetransmit(m)
 	}
 	return nil
 }
 
+// pushRetransmit holds the message until the next LambdaP event.
+func (s *Session) pushRetransmit(m *Message) {
+	s.retransmitLock.Lock()
+	defer s.retransmitLock.Unlock()
+	s.retransmits = append(s.retransmits, m)
+}
+
+func (s *Session) popRetransmit() *Message {
+	s.retransmitLock.Lock()
+	defer s.retransmitLock.Unlock()
+	if len(s.retransmits) == 0 {
+		return nil
+	}
+	m := s.retransmits[0]
+	s.retransmits[0] = nil
+	s.retransmits = s.retransmits[1:]
+	return m
+}
+
+func (s *Session) retransmitsPending() int {
+	s.retransmitLock.Lock()
+	defer s.retransmitLock.Unlock()
+	return len(s.retransmits)
+}
+
 func (s *Session) doRetransmit(msg *Message) {
 	msg.Retransmissions++
 	msgIdStr := fmt.Sprintf("[%v]", hex.EncodeToString(msg.ID[:]))
@@ -87,58 +171,63 @@ func (s *Session) doSend(msg *Message) {
 	surbID := [sConstants.SURBIDLength]byte{}
 	_, err := io.ReadFull(rand.Reader, surbID[:])
 	if err != nil {
-		s.fatalErrCh <- fmt.Errorf("impossible failure, failed to generate SURB ID for message ID %x", *msg.ID)
+		s.fatal(fmt.Errorf("impossible failure, failed to generate SURB ID for message ID %x", *msg.ID))
 		return
 	}
 	key := []byte{}
 	var eta time.Duration
 	msgIdStr := fmt.Sprintf("[%v]", hex.EncodeToString(msg.ID[:]))
-	if msg.WithSURB {
-		msg.SURBID = &surbID
+	if msg.WithSURB && s.surbIDMap.IsFull() {
+		s.log.Warningf("doSend %s failed: %v", msgIdStr, ErrTooManyPendingReplies)
+		err = ErrTooManyPendingReplies
+	} else if msg.WithSURB {
 		surbIdStr := fmt.Sprintf("[%v]", hex.EncodeToString(surbID[:]))
-		s.log.Debugf("doSend %s with SURB ID %s", msgIdStr, surbIdStr)
-		key, eta, err = s.minclient.SendCiphertext(msg.Recipient, msg.Provider, &surbID, msg.Payload)
+		if err = s.surbIDMap.CheckUnused(surbID); err != nil {
+			s.log.Errorf("doSend %s with SURB ID %s failed: %v", msgIdStr, surbIdStr, err)
+		} else {
+			msg.SURBID = &surbID
+			s.log.Debugf("doSend %s with SURB ID %s", msgIdStr, surbIdStr)
+			key, eta, err = s.sendCiphertext(msg, &surbID)
+		}
 	} else {
 		s.log.Debugf("doSend %s without SURB", msgIdStr)
-		err = s.minclient.SendUnreliableCiphertext(msg.Recipient, msg.Provider, msg.Payload)
+		err = s.sendUnreliableCiphertext(msg)
 	}
 
 	// message was sent
 	if err == nil {
-		msg.SentAt = time.Now()
+		msg.SentAt = s.clock.Now()
+	}
+	if !msg.IsDecoy {
+		if err == nil {
+			s.serviceStats.onRequest(msg.Recipient, msg.Provider)
+		} else {
+			s.serviceStats.onSendError(msg.Recipient, msg.Provider)
+		}
 	}
 	// expect a reply
 	if msg.WithSURB {
 		if err == nil {
-			s.log.Debugf("doSend setting ReplyETA to %v", eta)
-			// increase the timeout for each retransmission
-			msg.ReplyETA = eta * (1 + time.Duration(msg.Retransmissions))
+			// the path delay is a lower bound on the observed round trip time
+			rto := s.rtt.RTO(msg.Provider, eta)
+			s.log.Debugf("doSend setting ReplyETA to %v (path ETA %v)", rto, eta)
+			// back off exponentially with each retransmission
+			maxDelay := time.Duration(s.cfg.Debug.MaxRetransmitDelay) * time.Second
+			msg.ReplyETA = backoff(rto, msg.Retransmissions, s.cfg.Debug.RetransmitBackoff, maxDelay)
 			msg.Key = key
-			s.surbIDMap.Store(surbID, msg)
+			msg.pathETA = eta
+			// Only the worker sends, so the capacity check above holds.
+			_ = s.surbIDMap.Store(surbID, msg)
 			if msg.Reliable {
 				s.log.Debugf("Sending reliable message with retransmissions")
-				timeSlop := eta // add a round-trip worth of delay before timing out
-				msg.QueuePriority = uint64(msg.SentAt.Add(msg.ReplyETA).Add(timeSlop).UnixNano())
+				// add a round-trip worth of delay before timing out
+				msg.QueuePriority = uint64(msg.SentAt.Add(msg.ReplyETA).Add(msg.pathETA).UnixNano())
 				s.rescheduler.timerQ.Push(msg)
+				s.arqPut(msg)
 			}
 		}
-		// write to waiting channel or close channel if message failed to send
 		if msg.IsBlocking {
-			sentWaitChanRaw, ok := s.sentWaitChanMap.Load(*msg.ID)
-			if !ok {
-				return
-			}
-			sentWaitChan := sentWaitChanRaw.(chan *Message)
-			if err == nil {
-				// do not block writing to the receiver if this is a retransmission
-				select {
-				case sentWaitChan <- msg:
-				default:
-				}
-
-			} else {
-				close(sentWaitChan)
-			}
+			s.notifySent(msg, err)
 			return
 		}
 	}
@@ -152,10 +241,14 @@ func (s *Session) doSend(msg *Message) {
 
 func (s *Session) sendDropDecoy(loopSvc *utils.ServiceDescriptor) {
 	payload := make([]byte, constants.UserForwardPayloadLength)
+	if err := s.decoyPayloadFn(payload); err != nil {
+		s.log.Errorf("Failed to generate drop decoy payload: %v", err)
+		return
+	}
 	id := [cConstants.MessageIDLength]byte{}
 	_, err := io.ReadFull(rand.Reader, id[:])
 	if err != nil {
-		s.fatalErrCh <- errors.New("failure to generate message ID for drop decoy")
+		s.recovered("decoy", errors.New("failure to generate message ID for drop decoy"))
 		return
 	}
 	msg := &Message{
@@ -170,12 +263,21 @@ func (s *Session) sendDropDecoy(loopSvc *utils.ServiceDescriptor) {
 }
 
 func (s *Session) sendLoopDecoy(loopSvc *utils.ServiceDescriptor) {
+	if s.surbIDMap.IsFull() {
+		// Preserve the traffic pattern without adding another reply to wait on.
+		s.sendDropDecoy(loopSvc)
+		return
+	}
 	s.log.Info("sending loop decoy")
 	payload := make([]byte, constants.UserForwardPayloadLength)
+	if err := s.decoyPayloadFn(payload); err != nil {
+		s.log.Errorf("Failed to generate loop decoy payload: %v", err)
+		return
+	}
 	id := [cConstants.MessageIDLength]byte{}
 	_, err := io.ReadFull(rand.Reader, id[:])
 	if err != nil {
-		s.fatalErrCh <- errors.New("failure to generate message ID for loop decoy")
+		s.recovered("decoy", errors.New("failure to generate message ID for loop decoy"))
 		return
 	}
 	msg := &Message{
@@ -190,8 +292,45 @@ func (s *Session) sendLoopDecoy(loopSvc *utils.ServiceDescriptor) {
 	s.doSend(msg)
 }
 
+func (s *Session) checkCanSend() error {
+	s.mustBeInitialized()
+	if atomic.LoadUint32(&s.closing) != 0 {
+		return ErrSessionClosing
+	}
+	if s.maxMessages != 0 && atomic.LoadUint64(&s.messageCount) >= s.maxMessages {
+		return ErrMessageLimitReached
+	}
+	return nil
+}
+
+// pushMessage queues a composed message.  The message only counts
+// against the Session's message limit once it has been queued.
+func (s *Session) pushMessage(msg *Message) error {
+	if s.maxMessages == 0 {
+		return s.egressQueue.Push(msg)
+	}
+	if atomic.AddUint64(&s.messageCount, 1) > s.maxMessages {
+		atomic.AddUint64(&s.messageCount, ^uint64(0))
+		return ErrMessageLimitReached
+	}
+	if err := s.egressQueue.Push(msg); err != nil {
+		atomic.AddUint64(&s.messageCount, ^uint64(0))
+		return err
+	}
+	return nil
+}
+
 func (s *Session) composeMessage(recipient, provider string, message []byte, isBlocking bool) (*Message, error) {
 	s.log.Debug("SendMessage")
+	if err := s.checkCanSend(); err != nil {
+		return nil, err
+	}
+	for _, filter := range s.filters {
+		var err error
+		if message, err = filter.Filter(recipient, provider, message); err != nil {
+			return nil, fmt.Errorf("outgoing filter rejected message: %v", err)
+		}
+	}
 	if len(message) > constants.UserForwardPayloadLength-4 {
 		return nil, fmt.Errorf("invalid message size: %v", len(message))
 	}
@@ -210,43 +349,168 @@ func (s *Session) composeMessage(recipient, provider string, message []byte, isB
 		Payload:    payload[:],
 		WithSURB:   true,
 		IsBlocking: isBlocking,
+		QueuedAt:   s.clock.Now(),
 	}
 	return &msg, nil
 }
 
-// SendReliableMessage asynchronously sends messages with automatic retransmissiosn.
-func (s *Session) SendReliableMessage(recipient, provider string, message []byte) (*[cConstants.MessageIDLength]byte, error) {
-	msg, err := s.composeMessage(recipient, provider, message, false)
+// SendMessage asynchronously sends a message, using automatic
+// retransmissions, redundant copies or no SURB if the recipient's
+// SendPolicy requires them.
+func (s *Session) SendMessage(recipient, provider string, message []byte) (*[cConstants.MessageIDLength]byte, error) {
+	return s.enqueueMessage(recipient, provider, message, s.SendPolicy(recipient, provider))
+}
+
+// SendForwardOnlyMessage asynchronously sends a message without a SURB.
+// No reply or acknowledgement can be received, so the message is never
+// retransmitted and no SURB decryption keys are kept for it.
+func (s *Session) SendForwardOnlyMessage(recipient, provider string, message []byte) (*[cConstants.MessageIDLength]byte, error) {
+	return s.enqueueMessage(recipient, provider, message, &config.SendPolicy{ForwardOnly: true})
+}
+
+// SendRedundantMessage asynchronously sends copies of a message, each
+// over an independently chosen route and in a separate LambdaP event,
+// using automatic retransmissions if the recipient's SendPolicy requires
+// them.  The copies share a message ID: a MessageSentEvent is sent for
+// each copy, but only the first reply is delivered.
+func (s *Session) SendRedundantMessage(recipient, provider string, message []byte, copies int) (*[cConstants.MessageIDLength]byte, error) {
+	if copies < 1 || copies > config.MaxCopies {
+		return nil, ErrInvalidCopies
+	}
+	policy := *s.SendPolicy(recipient, provider)
+	policy.Copies = copies
+	return s.enqueueMessage(recipient, provider, message, &policy)
+}
+
+// SendPolicy returns the SendPolicy which applies to the recipient.
+func (s *Session) SendPolicy(recipient, provider string) *config.SendPolicy {
+	return s.cfg.SendPolicyFor(recipient, provider)
+}
+
+// SendRawForward asynchronously sends payload as the entire forward
+// payload of a Sphinx packet, without the length prefix added by the
+// other Send methods.  The payload must be exactly
+// UserForwardPayloadLength bytes long; padding and framing are the
+// caller's responsibility.
+//
+// Anything that makes raw payloads distinguishable to the recipient's
+// Provider or the service, such as unpadded framing or a distinctive
+// header, reduces the anonymity set of the sender to the other users of
+// that framing.  Prefer SendMessage unless a custom framing is required.
+func (s *Session) SendRawForward(recipient, provider string, payload []byte, opts *RawSendOptions) (*[cConstants.MessageIDLength]byte, error) {
+	s.log.Debug("SendRawForward")
+	if opts == nil {
+		opts = &RawSendOptions{}
+	}
+	if opts.Reliable && !opts.WithSURB {
+		return nil, errors.New("reliable raw messages require a SURB")
+	}
+	if len(payload) != constants.UserForwardPayloadLength {
+		return nil, ErrInvalidRawPayloadSize
+	}
+	if opts.Copies < 0 || opts.Copies > config.MaxCopies {
+		return nil, ErrInvalidCopies
+	}
+	if err := s.checkCanSend(); err != nil {
+		return nil, err
+	}
+	id := [cConstants.MessageIDLength]byte{}
+	_, err := io.ReadFull(rand.Reader, id[:])
 	if err != nil {
 		return nil, err
 	}
-	msg.Reliable = true
-	err = s.egressQueue.Push(msg)
+	msg := &Message{
+		ID:        &id,
+		Recipient: recipient,
+		Provider:  provider,
+		Payload:   append([]byte{}, payload...),
+		WithSURB:  opts.WithSURB,
+		Reliable:  opts.Reliable,
+		QueuedAt:  s.clock.Now(),
+	}
+	dups := redundantCopies(msg, opts.Copies)
+	err = s.pushMessage(msg)
 	if err != nil {
 		return nil, err
 	}
+	s.enqueueCopies(dups)
 	return msg.ID, nil
 }
 
+// SendReliableMessage asynchronously sends messages with automatic retransmissiosn.
+func (s *Session) SendReliableMessage(recipient, provider string, message []byte) (*[cConstants.MessageIDLength]byte, error) {
+	return s.enqueueMessage(recipient, provider, message, &config.SendPolicy{Reliable: true})
+}
+
 // SendUnreliableMessage asynchronously sends message without any automatic retransmissions.
 func (s *Session) SendUnreliableMessage(recipient, provider string, message []byte) (*[cConstants.MessageIDLength]byte, error) {
+	return s.enqueueMessage(recipient, provider, message, &config.SendPolicy{})
+}
+
+func (s *Session) enqueueMessage(recipient, provider string, message []byte, policy *config.SendPolicy) (*[cConstants.MessageIDLength]byte, error) {
+	if s.dedup == nil {
+		return s.queueMessage(recipient, provider, message, policy)
+	}
+	id, dup, err := s.dedup.Submit(s.clock.Now(), recipient, provider, policy, message, func() (*[cConstants.MessageIDLength]byte, error) {
+		return s.queueMessage(recipient, provider, message, policy)
+	})
+	if dup {
+		s.log.Debugf("Collapsing duplicate submission into message %x", *id)
+	}
+	return id, err
+}
+
+func (s *Session) queueMessage(recipient, provider string, message []byte, policy *config.SendPolicy) (*[cConstants.MessageIDLength]byte, error) {
 	msg, err := s.composeMessage(recipient, provider, message, false)
 	if err != nil {
 		return nil, err
 	}
-	err = s.egressQueue.Push(msg)
+	msg.Reliable = policy.Reliable
+	msg.WithSURB = !policy.ForwardOnly
+	dups := redundantCopies(msg, policy.Copies)
+	err = s.pushMessage(msg)
 	if err != nil {
 		return nil, err
 	}
+	s.enqueueCopies(dups)
 	return msg.ID, nil
 }
 
+// redundantCopies links msg to copies-1 new copies of it, which must be
+// made before msg is queued.
+func redundantCopies(msg *Message, copies int) []*Message {
+	if copies <= 1 {
+		return nil
+	}
+	msg.group = new(sendGroup)
+	dups := make([]*Message, 0, copies-1)
+	for i := 1; i < copies; i++ {
+		dup := *msg
+		dup.Payload = append([]byte{}, msg.Payload...)
+		dup.isCopy = true
+		dups = append(dups, &dup)
+	}
+	return dups
+}
+
+// enqueueCopies queues the redundant copies of a queued message.  The
+// message has already been accepted, so a copy refused by the egress
+// queue only reduces the redundancy.
+func (s *Session) enqueueCopies(dups []*Message) {
+	for _, dup := range dups {
+		if err := s.egressQueue.Push(dup); err != nil {
+			s.log.Warningf("Failed to queue a copy of message %x: %v", *dup.ID, err)
+			return
+		}
+	}
+}
+
 func (s *Session) BlockingSendUnreliableMessage(recipient, provider string, message []byte) ([]byte, error) {
 	msg, err := s.composeMessage(recipient, provider, message, true)
 	if err != nil {
 		return nil, err
 	}
-	sentWaitChan := make(chan *Message)
+	sentWaitChan := make(chan *Message, 1)
 	s.sentWaitChanMap.Store(*msg.ID, sentWaitChan)
 	defer s.sentWaitChanMap.Delete(*msg.ID)
 
@@ -254,7 +518,7 @@ func (s *Session) BlockingSendUnreliableMessage(recipient, provider string, mess
 	s.replyWaitChanMap.Store(*msg.ID, replyWaitChan)
 	defer s.replyWaitChanMap.Delete(*msg.ID)
 
-	err = s.egressQueue.Push(msg)
+	err = s.pushMessage(msg)
 	if err != nil {
 		return nil, err
 	}
@@ -285,7 +549,7 @@ func (s *Session) BlockingSendReliableMessage(recipient, provider string, messag
 		return nil, err
 	}
 	msg.Reliable = true
-	sentWaitChan := make(chan *Message)
+	sentWaitChan := make(chan *Message, 1)
 	s.sentWaitChanMap.Store(*msg.ID, sentWaitChan)
 	defer s.sentWaitChanMap.Delete(*msg.ID)
 
@@ -293,7 +557,7 @@ func (s *Session) BlockingSendReliableMessage(recipient, provider string, messag
 	s.replyWaitChanMap.Store(*msg.ID, replyWaitChan)
 	defer s.replyWaitChanMap.Delete(*msg.ID)
 
-	err = s.egressQueue.Push(msg)
+	err = s.pushMessage(msg)
 	if err != nil {
 		return nil, err
 	}
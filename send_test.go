@@ -0,0 +1,324 @@
+// send_test.go - Message sending tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/client/config"
+	cConstants "github.com/katzenpost/client/constants"
+	"github.com/katzenpost/core/constants"
+	sConstants "github.com/katzenpost/core/sphinx/constants"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMessage(n byte, recipient string) *Message {
+	id := [cConstants.MessageIDLength]byte{n}
+	return &Message{
+		ID:        &id,
+		Recipient: recipient,
+		Provider:  "acme",
+		Payload:   []byte("payload"),
+	}
+}
+
+func TestRedundantCopies(t *testing.T) {
+	assert := assert.New(t)
+
+	id := [cConstants.MessageIDLength]byte{1}
+	msg := &Message{ID: &id, Payload: []byte("payload")}
+	assert.Empty(redundantCopies(msg, 1))
+	assert.Nil(msg.group)
+
+	dups := redundantCopies(msg, 3)
+	assert.Len(dups, 2)
+	for _, dup := range dups {
+		assert.Equal(msg.ID, dup.ID)
+		assert.Equal(msg.Payload, dup.Payload)
+		assert.True(msg.group == dup.group)
+	}
+
+	// The copies do not share the payload, which is wiped per copy.
+	dups[0].wipe()
+	assert.Equal([]byte("payload"), msg.Payload)
+
+	assert.True(msg.group.reply())
+	assert.False(dups[1].group.reply())
+	assert.True(dups[0].group.isReplied())
+}
+
+func TestPushWhileSending(t *testing.T) {
+	require := require.New(t)
+
+	mc := new(fakeMinclient)
+	s := testSession(t, NewManualClock(time.Unix(0, 0)), mc)
+	defer haltTestSession(s)
+	s.egressQueue = NewQueue(2, EvictOldest, s.onEvicted)
+
+	msg := testMessage(1, "bob")
+	msg.WithSURB = true
+	msg.IsBlocking = true
+	sentWaitChan := make(chan *Message, 1)
+	s.sentWaitChanMap.Store(*msg.ID, sentWaitChan)
+	require.NoError(s.egressQueue.Push(msg))
+	require.NoError(s.egressQueue.Push(testMessage(2, "carol")))
+
+	// Fill the queue from other goroutines while the head is being sent.
+	mc.onSend = func() {
+		mc.onSend = nil
+		wg := new(sync.WaitGroup)
+		for i := byte(3); i < 6; i++ {
+			wg.Add(1)
+			go func(i byte) {
+				defer wg.Done()
+				assert.NoError(t, s.egressQueue.Push(testMessage(i, "dave")))
+			}(i)
+		}
+		wg.Wait()
+	}
+	s.sendNext()
+
+	require.Equal([]string{"bob"}, mc.sentTo())
+	require.True(msg == <-sentWaitChan)
+	require.Equal(1, egressQueueLen(s.egressQueue))
+	head, err := s.egressQueue.Peek()
+	require.NoError(err)
+	require.Equal("dave", head.(*Message).Recipient)
+
+	// Only the queued messages behind the head were evicted.
+	for i := 0; i < 3; i++ {
+		e := (<-s.eventCh.Out()).(*MessageSentEvent)
+		require.Equal(ErrMessageEvicted, e.Err)
+		require.NotEqual(*msg.ID, *e.MessageID)
+	}
+	require.Zero(s.eventCh.Len())
+}
+
+// sentCopies returns a reliable message and its redundant copies, sent
+// and awaiting replies.
+func sentCopies(t *testing.T, s *Session, n byte) []*Message {
+	msg := testMessage(n, "bob")
+	msg.WithSURB = true
+	msg.Reliable = true
+	msgs := append([]*Message{msg}, redundantCopies(msg, 3)...)
+	for i, m := range msgs {
+		m.SentAt = s.clock.Now()
+		m.ReplyETA = time.Second
+		s.arqPut(m)
+		surbID := [sConstants.SURBIDLength]byte{n, byte(i)}
+		require.NoError(t, s.surbIDMap.Store(surbID, m))
+	}
+	return msgs
+}
+
+func TestGarbageCollectCopies(t *testing.T) {
+	require := require.New(t)
+
+	clock := NewManualClock(epoch0)
+	s := testSession(t, clock, new(fakeMinclient))
+	defer haltTestSession(s)
+	store := newMemARQStore()
+	s.arqStore = store
+
+	// The copies share one retransmission entry.
+	msgs := sentCopies(t, s, 1)
+	require.Equal(1, store.Len())
+
+	// Once a copy is replied to, the others expire silently.
+	require.True(msgs[0].group.reply())
+	clock.Advance(time.Second + cConstants.RoundTripTimeSlop + time.Millisecond)
+	s.garbageCollect()
+	require.Zero(s.eventCh.Len())
+	require.Zero(store.Len())
+	require.Nil(s.serviceStats.Provider("acme"))
+
+	// An unanswered copy expiring does not forget the original's state.
+	msgs = sentCopies(t, s, 2)
+	_, ok := s.surbIDMap.LoadAndDelete([sConstants.SURBIDLength]byte{2, 0}, clock.Now())
+	require.True(ok)
+	clock.Advance(time.Second + cConstants.RoundTripTimeSlop + time.Millisecond)
+	s.garbageCollect()
+	require.Equal(1, store.Len())
+	for i := 0; i < 3; i++ {
+		e := (<-s.eventCh.Out()).(*MessageIDGarbageCollected)
+		require.Equal(*msgs[0].ID, *e.MessageID)
+	}
+}
+
+func TestGarbageCollectLongPath(t *testing.T) {
+	require := require.New(t)
+
+	// A path delay longer than the round trip slop does not expire a
+	// reliable message before its retransmission timer fires.
+	eta := cConstants.RoundTripTimeSlop + 30*time.Second
+	clock := NewManualClock(epoch0)
+	s := testSession(t, clock, &fakeMinclient{eta: eta})
+	defer haltTestSession(s)
+	msg := testMessage(1, "bob")
+	msg.WithSURB = true
+	msg.Reliable = true
+	s.doSend(msg)
+	require.NoError((<-s.eventCh.Out()).(*MessageSentEvent).Err)
+	require.Equal(eta, msg.ReplyETA)
+
+	clock.Advance(eta + cConstants.RoundTripTimeSlop + time.Millisecond)
+	s.garbageCollect()
+	require.Equal(1, s.PendingReplies())
+	require.Zero(s.eventCh.Len())
+	require.Equal([]byte("payload"), msg.Payload)
+
+	clock.Advance(eta)
+	require.Eventually(func() bool {
+		return s.retransmitsPending() == 1
+	}, time.Second, time.Millisecond)
+	require.True(s.popRetransmit() == msg)
+	require.Zero(s.PendingReplies())
+	require.Equal([]byte("payload"), msg.Payload)
+}
+
+func TestProviderFailures(t *testing.T) {
+	require := require.New(t)
+
+	clock := NewManualClock(epoch0)
+	mc := &fakeMinclient{sendErr: errors.New("connection lost")}
+	s := testSession(t, clock, mc)
+	defer haltTestSession(s)
+
+	// A message which is not sent does not count against the Provider.
+	for i := byte(0); i < providerFailingThreshold; i++ {
+		s.doSend(testMessage(i, "bob"))
+		require.Error((<-s.eventCh.Out()).(*MessageSentEvent).Err)
+	}
+	stats := s.ProviderStats("acme")
+	require.Equal(uint64(providerFailingThreshold), stats.Failures)
+	require.Zero(stats.ConsecutiveFailures)
+	require.False(s.isProviderFailing("acme"))
+
+	for i := 0; i < providerFailingThreshold; i++ {
+		s.recordFailure("bob", "acme")
+	}
+	require.IsType(new(ProviderFailingEvent), <-s.eventCh.Out())
+	require.True(s.isProviderFailing("acme"))
+
+	// A failing Provider is tried again after a while, and avoided again
+	// if it fails.
+	clock.Advance(providerRetryInterval)
+	require.False(s.isProviderFailing("acme"))
+	s.recordFailure("bob", "acme")
+	require.True(s.isProviderFailing("acme"))
+	require.Zero(s.eventCh.Len())
+}
+
+func TestSendRawForward(t *testing.T) {
+	require := require.New(t)
+
+	s := testSession(t, NewManualClock(epoch0), new(fakeMinclient))
+	defer haltTestSession(s)
+
+	payload := make([]byte, constants.UserForwardPayloadLength)
+	payload[0] = 1
+	_, err := s.SendRawForward("bob", "acme", payload[1:], nil)
+	require.Equal(ErrInvalidRawPayloadSize, err)
+	_, err = s.SendRawForward("bob", "acme", payload, &RawSendOptions{Reliable: true})
+	require.Error(err)
+	_, err = s.SendRawForward("bob", "acme", payload, &RawSendOptions{Copies: config.MaxCopies + 1})
+	require.Equal(ErrInvalidCopies, err)
+	require.Zero(egressQueueLen(s.egressQueue))
+
+	// The payload is queued as is, without a length prefix.
+	id, err := s.SendRawForward("bob", "acme", payload, &RawSendOptions{WithSURB: true, Reliable: true, Copies: 2})
+	require.NoError(err)
+	payload[0] = 2
+	require.Equal(2, egressQueueLen(s.egressQueue))
+	for i := 0; i < 2; i++ {
+		item, err := s.egressQueue.Pop()
+		require.NoError(err)
+		msg := item.(*Message)
+		require.Equal(*id, *msg.ID)
+		require.True(msg.WithSURB)
+		require.True(msg.Reliable)
+		require.Len(msg.Payload, constants.UserForwardPayloadLength)
+		require.Equal(byte(1), msg.Payload[0])
+	}
+}
+
+func TestRawMessageHandler(t *testing.T) {
+	require := require.New(t)
+
+	s := testSession(t, NewManualClock(epoch0), new(fakeMinclient))
+	defer haltTestSession(s)
+	require.NoError(s.onMessage([]byte("ignored")))
+
+	received := [][]byte{}
+	s.rawHandler = func(b []byte) {
+		received = append(received, b)
+	}
+	require.NoError(s.onMessage([]byte("ciphertext")))
+	require.Equal([][]byte{[]byte("ciphertext")}, received)
+	require.Equal(uint64(2), s.receivedCount)
+}
+
+func TestOutgoingFilters(t *testing.T) {
+	require := require.New(t)
+
+	clock := NewManualClock(epoch0)
+	s := testSession(t, clock, new(fakeMinclient))
+	defer haltTestSession(s)
+	suffix := func(suffix string) OutgoingFilter {
+		return OutgoingFilterFunc(func(recipient, provider string, message []byte) ([]byte, error) {
+			require.Equal("bob", recipient)
+			require.Equal("acme", provider)
+			return append(message, suffix...), nil
+		})
+	}
+
+	// Filters are applied in the order they were added.
+	s.filters = []OutgoingFilter{suffix(" a"), suffix(" b")}
+	msg, err := s.composeMessage("bob", "acme", []byte("hello"), false)
+	require.NoError(err)
+	want := []byte("hello a b")
+	require.Equal(uint32(len(want)), binary.BigEndian.Uint32(msg.Payload[:4]))
+	require.Equal(want, msg.Payload[4:4+len(want)])
+
+	// A rejection stops the pipeline and the message is not sent.
+	called := false
+	s.filters = []OutgoingFilter{
+		OutgoingFilterFunc(func(recipient, provider string, message []byte) ([]byte, error) {
+			return nil, errors.New("forbidden")
+		}),
+		OutgoingFilterFunc(func(recipient, provider string, message []byte) ([]byte, error) {
+			called = true
+			return message, nil
+		}),
+	}
+	_, err = s.SendMessage("bob", "acme", []byte("hello"))
+	require.EqualError(err, "outgoing filter rejected message: forbidden")
+	require.False(called)
+	require.Zero(egressQueueLen(s.egressQueue))
+
+	// The filtered message must still fit in a payload.
+	s.filters = []OutgoingFilter{OutgoingFilterFunc(func(recipient, provider string, message []byte) ([]byte, error) {
+		return make([]byte, constants.UserForwardPayloadLength), nil
+	})}
+	_, err = s.composeMessage("bob", "acme", []byte("hello"), false)
+	require.Error(err)
+}
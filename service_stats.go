@@ -0,0 +1,264 @@
+// service_stats.go - Kaetzchen service statistics
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxLatencySamples is the number of most recent reply latencies
+	// kept per service for computing percentiles.
+	maxLatencySamples = 128
+
+	// providerFailingThreshold is the number of consecutive failures
+	// after which a Provider is considered to be failing.
+	providerFailingThreshold = 5
+
+	// providerRetryInterval is how long after its last failure a failing
+	// Provider is avoided before its services are tried again.
+	providerRetryInterval = 5 * time.Minute
+)
+
+// ServiceStats are the statistics for the messages sent to a single
+// Kaetzchen service.
+type ServiceStats struct {
+	// Requests is the number of messages sent to the service,
+	// including retransmissions.
+	Requests uint64
+
+	// Replies is the number of replies received from the service.
+	Replies uint64
+
+	// Failures is the number of messages which either failed to be
+	// sent or whose reply never arrived.
+	Failures uint64
+
+	// ReplyBytes is the total size of the reply payloads received.
+	ReplyBytes uint64
+
+	// LatencyP50, LatencyP90 and LatencyP99 are percentiles of the
+	// round trip time of recent replies.
+	LatencyP50 time.Duration
+	LatencyP90 time.Duration
+	LatencyP99 time.Duration
+}
+
+// SuccessRate returns the fraction of requests which received a reply.
+func (s *ServiceStats) SuccessRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Replies) / float64(s.Requests)
+}
+
+// ProviderStats are the statistics for the messages sent to all of the
+// services of a single Provider.
+type ProviderStats struct {
+	// Requests, Replies and Failures are the sums of the ServiceStats
+	// of the Provider's services.
+	Requests uint64
+	Replies  uint64
+	Failures uint64
+
+	// ConsecutiveFailures is the number of replies which never arrived
+	// since the last reply was received from any of the Provider's
+	// services.
+	ConsecutiveFailures uint64
+
+	// LastFailure is when the last reply which never arrived was given
+	// up on.
+	LastFailure time.Time
+
+	// SRTT is the smoothed round trip time of replies from the
+	// Provider, or zero if no reply has been received.
+	SRTT time.Duration
+}
+
+// SuccessRate returns the fraction of requests which received a reply.
+func (s *ProviderStats) SuccessRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Replies) / float64(s.Requests)
+}
+
+// IsFailing returns true if the Provider's services have consistently
+// failed to reply.
+func (s *ProviderStats) IsFailing() bool {
+	return s.ConsecutiveFailures >= providerFailingThreshold
+}
+
+type serviceRecord struct {
+	stats     ServiceStats
+	latencies []time.Duration
+	next      int
+}
+
+type serviceStatsTracker struct {
+	sync.Mutex
+
+	services         map[string]*serviceRecord
+	providerFailures map[string]uint64
+	providerFailedAt map[string]time.Time
+	providers        map[string]string // service key -> provider
+}
+
+func newServiceStatsTracker() *serviceStatsTracker {
+	return &serviceStatsTracker{
+		services:         make(map[string]*serviceRecord),
+		providerFailures: make(map[string]uint64),
+		providerFailedAt: make(map[string]time.Time),
+		providers:        make(map[string]string),
+	}
+}
+
+func serviceKey(recipient, provider string) string {
+	return fmt.Sprintf("%s@%s", recipient, provider)
+}
+
+func (t *serviceStatsTracker) record(recipient, provider string) *serviceRecord {
+	key := serviceKey(recipient, provider)
+	r, ok := t.services[key]
+	if !ok {
+		r = &serviceRecord{}
+		t.services[key] = r
+		t.providers[key] = provider
+	}
+	return r
+}
+
+func (t *serviceStatsTracker) onRequest(recipient, provider string) {
+	t.Lock()
+	defer t.Unlock()
+	t.record(recipient, provider).stats.Requests++
+}
+
+// onSendError records a message which failed to be sent.  The Provider
+// was never reached, so this does not count against it.
+func (t *serviceStatsTracker) onSendError(recipient, provider string) {
+	t.Lock()
+	defer t.Unlock()
+	t.record(recipient, provider).stats.Failures++
+}
+
+// onFailure records a reply which never arrived at now and returns the
+// number of consecutive failures of the Provider.
+func (t *serviceStatsTracker) onFailure(recipient, provider string, now time.Time) uint64 {
+	t.Lock()
+	defer t.Unlock()
+	t.record(recipient, provider).stats.Failures++
+	t.providerFailures[provider]++
+	t.providerFailedAt[provider] = now
+	return t.providerFailures[provider]
+}
+
+func (t *serviceStatsTracker) onReply(recipient, provider string, latency time.Duration, size int) {
+	t.Lock()
+	defer t.Unlock()
+	r := t.record(recipient, provider)
+	t.providerFailures[provider] = 0
+	r.stats.Replies++
+	r.stats.ReplyBytes += uint64(size)
+	if len(r.latencies) < maxLatencySamples {
+		r.latencies = append(r.latencies, latency)
+	} else {
+		r.latencies[r.next] = latency
+		r.next = (r.next + 1) % maxLatencySamples
+	}
+}
+
+func (t *serviceStatsTracker) get(key string) *ServiceStats {
+	r, ok := t.services[key]
+	if !ok {
+		return nil
+	}
+	stats := r.stats
+	if len(r.latencies) != 0 {
+		sorted := make([]time.Duration, len(r.latencies))
+		copy(sorted, r.latencies)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		percentile := func(p int) time.Duration {
+			return sorted[(len(sorted)-1)*p/100]
+		}
+		stats.LatencyP50 = percentile(50)
+		stats.LatencyP90 = percentile(90)
+		stats.LatencyP99 = percentile(99)
+	}
+	return &stats
+}
+
+// Stats returns the statistics for the service, or nil if no messages
+// have been sent to it.
+func (t *serviceStatsTracker) Stats(recipient, provider string) *ServiceStats {
+	t.Lock()
+	defer t.Unlock()
+	return t.get(serviceKey(recipient, provider))
+}
+
+// All returns the statistics for every service, keyed by recipient@provider.
+func (t *serviceStatsTracker) All() map[string]*ServiceStats {
+	t.Lock()
+	defer t.Unlock()
+	all := make(map[string]*ServiceStats)
+	for key := range t.services {
+		all[key] = t.get(key)
+	}
+	return all
+}
+
+// Provider returns the statistics for the Provider, or nil if no
+// messages have been sent to it.
+func (t *serviceStatsTracker) Provider(provider string) *ProviderStats {
+	t.Lock()
+	defer t.Unlock()
+	var stats *ProviderStats
+	for key, r := range t.services {
+		if t.providers[key] != provider {
+			continue
+		}
+		if stats == nil {
+			stats = &ProviderStats{
+				ConsecutiveFailures: t.providerFailures[provider],
+				LastFailure:         t.providerFailedAt[provider],
+			}
+		}
+		stats.Requests += r.stats.Requests
+		stats.Replies += r.stats.Replies
+		stats.Failures += r.stats.Failures
+	}
+	return stats
+}
+
+// Providers returns the names of every Provider messages have been sent to.
+func (t *serviceStatsTracker) Providers() []string {
+	t.Lock()
+	defer t.Unlock()
+	seen := make(map[string]bool)
+	providers := []string{}
+	for _, provider := range t.providers {
+		if !seen[provider] {
+			seen[provider] = true
+			providers = append(providers, provider)
+		}
+	}
+	sort.Strings(providers)
+	return providers
+}
@@ -0,0 +1,93 @@
+// service_stats_test.go - Kaetzchen service statistics tests
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceStats(t *testing.T) {
+	assert := assert.New(t)
+
+	tr := newServiceStatsTracker()
+	assert.Nil(tr.Stats("echo", "acme"))
+
+	for i := 1; i <= 100; i++ {
+		tr.onRequest("echo", "acme")
+		tr.onReply("echo", "acme", time.Duration(i)*time.Millisecond, 10)
+	}
+	tr.onRequest("echo", "acme")
+	tr.onFailure("echo", "acme", time.Unix(0, 0))
+
+	stats := tr.Stats("echo", "acme")
+	assert.Equal(uint64(101), stats.Requests)
+	assert.Equal(uint64(100), stats.Replies)
+	assert.Equal(uint64(1), stats.Failures)
+	assert.Equal(uint64(1000), stats.ReplyBytes)
+	assert.Equal(50*time.Millisecond, stats.LatencyP50)
+	assert.Equal(90*time.Millisecond, stats.LatencyP90)
+	assert.Equal(99*time.Millisecond, stats.LatencyP99)
+	assert.InDelta(100.0/101.0, stats.SuccessRate(), 0.0001)
+
+	// Only the most recent samples are used for the percentiles.
+	for i := 0; i < maxLatencySamples; i++ {
+		tr.onReply("echo", "acme", time.Second, 10)
+	}
+	assert.Equal(time.Second, tr.Stats("echo", "acme").LatencyP50)
+
+	all := tr.All()
+	assert.Len(all, 1)
+	assert.Contains(all, "echo@acme")
+}
+
+func TestProviderStats(t *testing.T) {
+	assert := assert.New(t)
+
+	tr := newServiceStatsTracker()
+	assert.Nil(tr.Provider("acme"))
+
+	tr.onRequest("echo", "acme")
+	tr.onReply("echo", "acme", time.Second, 10)
+	for i := uint64(1); i <= providerFailingThreshold; i++ {
+		tr.onRequest("keyserver", "acme")
+		assert.Equal(i, tr.onFailure("keyserver", "acme", time.Unix(int64(i), 0)))
+	}
+	tr.onRequest("echo", "example")
+	tr.onFailure("echo", "example", time.Unix(0, 0))
+
+	stats := tr.Provider("acme")
+	assert.Equal(uint64(1+providerFailingThreshold), stats.Requests)
+	assert.Equal(uint64(1), stats.Replies)
+	assert.Equal(uint64(providerFailingThreshold), stats.Failures)
+	assert.True(stats.IsFailing())
+	assert.Equal(time.Unix(providerFailingThreshold, 0), stats.LastFailure)
+	assert.False(tr.Provider("example").IsFailing())
+	assert.Equal([]string{"acme", "example"}, tr.Providers())
+
+	// A message which failed to be sent never reached the Provider.
+	tr.onSendError("echo", "example")
+	stats = tr.Provider("example")
+	assert.Equal(uint64(2), stats.Failures)
+	assert.Equal(uint64(1), stats.ConsecutiveFailures)
+
+	// A reply from any of the Provider's services resets the failures.
+	tr.onReply("echo", "acme", time.Second, 10)
+	assert.False(tr.Provider("acme").IsFailing())
+}
@@ -28,10 +28,12 @@ import (
 
 	"github.com/katzenpost/client/config"
 	cConstants "github.com/katzenpost/client/constants"
+	"github.com/katzenpost/client/internal/eventlog"
 	"github.com/katzenpost/client/internal/pkiclient"
 	"github.com/katzenpost/client/utils"
 	coreConstants "github.com/katzenpost/core/constants"
 	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/epochtime"
 	"github.com/katzenpost/core/log"
 	"github.com/katzenpost/core/pki"
 	"github.com/katzenpost/core/sphinx"
@@ -42,72 +44,178 @@ import (
 	"gopkg.in/op/go-logging.v1"
 )
 
+// closePollInterval is how often Close checks for outstanding messages.
+const closePollInterval = 100 * time.Millisecond
+
 // Session is the struct type that keeps state for a given session.
 type Session struct {
 	worker.Worker
 
 	cfg       *config.Config
 	pkiClient pki.Client
-	minclient *minclient.Client
-	log       *logging.Logger
+	minclient Minclient
+
+	pkiCacheClient *pkiclient.Client
+	ownsPKICache   bool
+
+	log   *logging.Logger
+	clock TimerClock
 
 	fatalErrCh chan error
 	opCh       chan workerOp
 
-	eventCh   channels.Channel
+	eventCh channels.Channel
+
+	// EventSink is the channel the Session's events are delivered on.  It
+	// is created by NewSession and must only be received from.  It stays
+	// exported because callers read events from it directly.
 	EventSink chan Event
 
 	linkKey   *ecdh.PrivateKey
+	createdAt time.Time
 	onlineAt  time.Time
 	hasPKIDoc bool
 
 	egressQueue EgressQueue
 	rescheduler *rescheduler
 
-	surbIDMap        sync.Map // [sConstants.SURBIDLength]byte -> *Message
+	retransmitLock sync.Mutex
+	retransmits    []*Message
+
+	surbIDMap        *surbMap
+	rtt              *rttEstimator
+	serviceStats     *serviceStatsTracker
 	sentWaitChanMap  sync.Map // MessageID -> chan *Message
 	replyWaitChanMap sync.Map // MessageID -> chan []byte
 
 	decoyLoopTally uint64
+	receivedCount  uint64
+
+	checkMailLock sync.Mutex
+	lastCheckMail time.Time
+
+	pollLock     sync.Mutex
+	pollInterval time.Duration
+
+	maxMessages  uint64
+	messageCount uint64
+
+	decoyPayloadFn DecoyPayloadFn
+	decoyServiceFn DecoyServiceFn
+	rawHandler     RawMessageHandler
+	dedup          *dedupFilter
+	docPolicies    []DocPolicy
+	arqStore       ARQStore
+	filters        []OutgoingFilter
+	eventLog       *eventlog.Log
+	proofHandler   DeliveryProofHandler
+	decoysDegraded uint32
+	providerWatch  providerWatch
+	chaos          *chaos
+	scheduleRng    *mrand.Rand
+
+	// workerHook, if set, is called by the worker after it handles each
+	// event, so that tests can step it deterministically.
+	workerHook func()
+
+	connected    uint32
+	dormant      uint32
+	closing      uint32
+	shutdownOnce sync.Once
+}
+
+// pkiCache returns the caching PKI client for minclient's lookups, and
+// true if the Session owns it and must halt it.  Sessions sharing a
+// caching client also share its documents, and leave halting it to the
+// Client.
+func pkiCache(pkiClient pki.Client) (*pkiclient.Client, bool) {
+	if c, ok := pkiClient.(*pkiclient.Client); ok {
+		return c, false
+	}
+	return pkiclient.New(pkiClient), true
 }
 
-// New establishes a session with provider using key.
+// NewSession establishes a session with provider using key.
 // This method will block until session is connected to the Provider.
 func NewSession(
 	ctx context.Context,
 	fatalErrCh chan error,
 	logBackend *log.Backend,
 	cfg *config.Config,
-	linkKey *ecdh.PrivateKey) (*Session, error) {
+	linkKey *ecdh.PrivateKey,
+	opts ...SessionOption) (*Session, error) {
+	if err := checkSessionArgs(fatalErrCh, logBackend, cfg, linkKey); err != nil {
+		return nil, err
+	}
 	var err error
+	o := newSessionOptions(opts)
 
-	// create a pkiclient for our own client lookups
-	// AND create a pkiclient for minclient's use
 	proxyCfg := cfg.UpstreamProxyConfig()
-	pkiClient, err := cfg.NewPKIClient(logBackend, proxyCfg)
-	if err != nil {
-		return nil, err
-	}
+	pkiClient, pkiClient2 := o.pkiClient, o.pkiClient
+	if pkiClient == nil {
+		// create a pkiclient for our own client lookups
+		pkiClient, err = cfg.NewPKIClient(logBackend, proxyCfg)
+		if err != nil {
+			return nil, err
+		}
 
-	// create a pkiclient for minclient's use
-	pkiClient2, err := cfg.NewPKIClient(logBackend, proxyCfg)
-	if err != nil {
-		return nil, err
+		// create a pkiclient for minclient's use
+		pkiClient2, err = cfg.NewPKIClient(logBackend, proxyCfg)
+		if err != nil {
+			return nil, err
+		}
 	}
-	pkiCacheClient := pkiclient.New(pkiClient2)
+	pkiCacheClient, ownsPKICache := pkiCache(pkiClient2)
 
 	clientLog := logBackend.GetLogger(fmt.Sprintf("%s@%s_client", cfg.Account.User, cfg.Account.Provider))
 
+	if o.pkiSeed != nil {
+		if err := pkiCacheClient.Seed(o.pkiSeed); err != nil {
+			clientLog.Warningf("Ignoring PKI seed document: %v", err)
+		}
+	}
+
 	s := &Session{
-		cfg:         cfg,
-		linkKey:     linkKey,
-		pkiClient:   pkiClient,
-		log:         clientLog,
-		fatalErrCh:  fatalErrCh,
-		eventCh:     channels.NewInfiniteChannel(),
-		EventSink:   make(chan Event),
-		opCh:        make(chan workerOp, 8),
-		egressQueue: new(Queue),
+		cfg:            cfg,
+		linkKey:        linkKey,
+		createdAt:      o.clock.Now(),
+		pkiClient:      pkiClient,
+		pkiCacheClient: pkiCacheClient,
+		ownsPKICache:   ownsPKICache,
+		log:            clientLog,
+		clock:          timerClock(o.clock),
+		fatalErrCh:     fatalErrCh,
+		eventCh:        channels.NewInfiniteChannel(),
+		EventSink:      make(chan Event),
+		opCh:           make(chan workerOp, 8),
+		egressQueue:    o.egressQueue,
+		surbIDMap:      newSURBMap(cConstants.MaxPendingReplies),
+		rtt:            newRTTEstimator(),
+		serviceStats:   newServiceStatsTracker(),
+		maxMessages:    o.maxMessages,
+		decoyPayloadFn: o.decoyPayloadFn,
+		decoyServiceFn: o.decoyServiceFn,
+		rawHandler:     o.rawHandler,
+		docPolicies:    append([]DocPolicy{loopixDocPolicy}, o.docPolicies...),
+		arqStore:       o.arqStore,
+		filters:        o.outgoingFilters,
+		eventLog:       o.eventLog,
+		proofHandler:   o.proofHandler,
+		chaos:          newChaos(cfg.Debug.Chaos),
+		scheduleRng:    o.scheduleRng,
+	}
+	if s.egressQueue == nil {
+		policy := RejectNewest
+		if cfg.Debug.EvictOldestQueued {
+			policy = EvictOldest
+		}
+		s.egressQueue = NewQueue(cfg.Debug.EgressQueueSize, policy, s.onEvicted)
+	}
+	if o.dedupWindow != 0 {
+		s.dedup = newDedupFilter(o.dedupWindow)
+	}
+	if s.chaos != nil {
+		s.log.Warning("Debug.Chaos is enabled: messages will be dropped, delayed and corrupted")
 	}
 	// Configure the rescheduler instance
 	s.rescheduler = NewRescheduler(s)
@@ -132,7 +240,7 @@ func NewSession(
 	s.Go(s.eventSinkWorker)
 	s.Go(s.garbageCollectionWorker)
 
-	s.minclient, err = minclient.New(clientCfg)
+	s.minclient, err = o.minclientFactory(clientCfg)
 	if err != nil {
 		return nil, err
 	}
@@ -143,7 +251,17 @@ func NewSession(
 	if err != nil {
 		return nil, err
 	}
-	s.Go(s.worker)
+	if s.arqStore != nil {
+		if err := s.restoreARQ(); err != nil {
+			s.log.Errorf("Failed to restore retransmission state: %v", err)
+		}
+	}
+	s.Go(func() {
+		s.supervise("worker", s.worker)
+	})
+	if s.isAdaptivePolling() {
+		s.Go(s.pollIntervalWorker)
+	}
 	return s, nil
 }
 
@@ -154,6 +272,7 @@ func (s *Session) eventSinkWorker() {
 			s.log.Debugf("Event sink worker terminating gracefully.")
 			return
 		case e := <-s.eventCh.Out():
+			s.recordEvent(e.(Event))
 			select {
 			case s.EventSink <- e.(Event):
 			case <-s.HaltCh():
@@ -165,36 +284,61 @@ func (s *Session) eventSinkWorker() {
 }
 
 func (s *Session) garbageCollectionWorker() {
-	timer := time.NewTimer(cConstants.GarbageCollectionInterval)
+	timer := s.clock.NewTimer(cConstants.GarbageCollectionInterval)
 	defer timer.Stop()
 	for {
 		select {
 		case <-s.HaltCh():
 			s.log.Debugf("Garbage collection worker terminating gracefully.")
 			return
-		case <-timer.C:
+		case <-timer.C():
 			s.garbageCollect()
 			timer.Reset(cConstants.GarbageCollectionInterval)
 		}
 	}
 }
 
+// surbLifetime returns how long a SURB ID must be remembered after use,
+// which is as long as the mix keys the SURB was made with remain valid.
+func surbLifetime() time.Duration {
+	return 2 * epochtime.Period
+}
+
 func (s *Session) garbageCollect() {
 	s.log.Debug("Running garbage collection process.")
-	// [sConstants.SURBIDLength]byte -> *Message
-	surbIDMapRange := func(rawSurbID, rawMessage interface{}) bool {
-		surbID := rawSurbID.([sConstants.SURBIDLength]byte)
-		message := rawMessage.(*Message)
-		if time.Now().After(message.SentAt.Add(message.ReplyETA).Add(cConstants.RoundTripTimeSlop)) {
-			s.log.Debug("Garbage collecting SURB ID Map entry for Message ID %x", message.ID)
-			s.surbIDMap.Delete(surbID)
-			s.eventCh.In() <- &MessageIDGarbageCollected{
-				MessageID: message.ID,
-			}
+	now := s.clock.Now()
+	expired := s.surbIDMap.Expire(now, cConstants.RoundTripTimeSlop)
+	// A SURB can not be used once the mix keys of its epoch are gone.
+	s.surbIDMap.ExpireConsumed(now.Add(-surbLifetime()))
+	for _, message := range expired {
+		s.log.Debugf("Garbage collecting SURB ID Map entry for Message ID %x", message.ID)
+		message.wipe()
+		s.arqDelete(message)
+		if message.IsDecoy {
+			s.decrementDecoyLoopTally()
+			continue
+		}
+		if message.group != nil && message.group.isReplied() {
+			// another copy was replied to
+			continue
+		}
+		s.recordFailure(message.Recipient, message.Provider)
+		s.eventCh.In() <- &MessageIDGarbageCollected{
+			MessageID: message.ID,
 		}
-		return true
 	}
-	s.surbIDMap.Range(surbIDMapRange)
+}
+
+// PendingReplies returns the number of sent messages which are
+// currently awaiting a SURB reply.
+func (s *Session) PendingReplies() int {
+	return s.surbIDMap.Len()
+}
+
+// ExpiredReplies returns the number of sent messages which were
+// garbage collected because their SURB reply never arrived.
+func (s *Session) ExpiredReplies() uint64 {
+	return s.surbIDMap.Expired()
 }
 
 func (s *Session) awaitFirstPKIDoc(ctx context.Context) error {
@@ -213,9 +357,9 @@ func (s *Session) awaitFirstPKIDoc(ctx context.Context) error {
 		switch op := qo.(type) {
 		case opNewDocument:
 			// Determine if PKI doc is valid. If not then abort.
-			err := s.isDocValid(op.doc)
+			err := s.checkDoc(op.doc)
 			if err != nil {
-				s.fatalErrCh <- fmt.Errorf("aborting, PKI doc is not valid for our decoy traffic use case: %v", err)
+				s.fatal(fmt.Errorf("aborting, %v", err))
 				return err
 			}
 			s.setPollIntervalFromDoc(op.doc)
@@ -228,7 +372,10 @@ func (s *Session) awaitFirstPKIDoc(ctx context.Context) error {
 }
 
 // GetService returns a randomly selected service
-// matching the specified service name
+// matching the specified service name, preferring
+// services on Providers which are not failing.
+// A failing Provider is tried again once it has
+// not failed for a while.
 func (s *Session) GetService(serviceName string) (*utils.ServiceDescriptor, error) {
 	doc := s.minclient.CurrentDocument()
 	if doc == nil {
@@ -238,13 +385,32 @@ func (s *Session) GetService(serviceName string) (*utils.ServiceDescriptor, erro
 	if len(serviceDescriptors) == 0 {
 		return nil, errors.New("error, GetService failure, service not found in pki doc")
 	}
+	healthy := []utils.ServiceDescriptor{}
+	for _, desc := range serviceDescriptors {
+		if !s.isProviderFailing(desc.Provider) {
+			healthy = append(healthy, desc)
+		}
+	}
+	if len(healthy) != 0 {
+		serviceDescriptors = healthy
+	}
 	return &serviceDescriptors[mrand.Intn(len(serviceDescriptors))], nil
 }
 
+// IsConnected returns true if the Session is connected to its Provider.
+func (s *Session) IsConnected() bool {
+	return atomic.LoadUint32(&s.connected) != 0
+}
+
 // OnConnection will be called by the minclient api
 // upon connection change status to the Provider
 func (s *Session) onConnection(err error) {
 	s.log.Debugf("onConnection %v", err)
+	if err == nil {
+		atomic.StoreUint32(&s.connected, 1)
+	} else {
+		atomic.StoreUint32(&s.connected, 0)
+	}
 	s.eventCh.In() <- &ConnectionStatusEvent{
 		IsConnected: err == nil,
 		Err:         err,
@@ -258,6 +424,10 @@ func (s *Session) onConnection(err error) {
 // upon receiving a message
 func (s *Session) onMessage(ciphertextBlock []byte) error {
 	s.log.Debugf("OnMessage")
+	atomic.AddUint64(&s.receivedCount, 1)
+	if s.rawHandler != nil {
+		s.rawHandler(s.chaos.corrupt(ciphertextBlock))
+	}
 	return nil
 }
 
@@ -271,17 +441,46 @@ func (s *Session) decrementDecoyLoopTally() {
 
 // OnACK is called by the minclient api when we receive a SURB reply message.
 func (s *Session) onACK(surbID *[sConstants.SURBIDLength]byte, ciphertext []byte) error {
+	ciphertext = s.chaos.corrupt(ciphertext)
+	if delay := s.chaos.ackDelay(); delay > 0 {
+		s.log.Debugf("chaos: delaying reply by %v", delay)
+		id := *surbID
+		ciphertext = append([]byte{}, ciphertext...)
+		timer := s.clock.NewTimer(delay)
+		s.Go(func() {
+			select {
+			case <-s.HaltCh():
+				timer.Stop()
+			case <-timer.C():
+				s.handleACK(&id, ciphertext)
+			}
+		})
+		return nil
+	}
+	return s.handleACK(surbID, ciphertext)
+}
+
+func (s *Session) handleACK(surbID *[sConstants.SURBIDLength]byte, ciphertext []byte) error {
 	idStr := fmt.Sprintf("[%v]", hex.EncodeToString(surbID[:]))
 	s.log.Infof("OnACK with SURBID %s", idStr)
+	atomic.AddUint64(&s.receivedCount, 1)
 
-	rawMessage, ok := s.surbIDMap.Load(*surbID)
+	msg, ok := s.surbIDMap.LoadAndDelete(*surbID, s.clock.Now())
 	if !ok {
+		if s.surbIDMap.IsConsumed(*surbID) {
+			s.log.Warningf("Discarding reply with SURB ID %s: %v", idStr, ErrSURBIDReused)
+			return nil
+		}
 		s.log.Debug("Strange, received reply with unexpected SURBID")
 		return nil
 	}
-	s.surbIDMap.Delete(*surbID)
-	msg := rawMessage.(*Message)
+	s.rtt.Observe(msg.Provider, s.clock.Now().Sub(msg.SentAt))
+	s.arqDelete(msg)
 	plaintext, err := sphinx.DecryptSURBPayload(ciphertext, msg.Key)
+	if err == nil && len(plaintext) == coreConstants.ForwardPayloadLength {
+		s.retainDeliveryProof(msg, ciphertext)
+	}
+	msg.wipe()
 	if err != nil {
 		s.log.Infof("Discarding SURB Reply, decryption failure: %s", err)
 		return nil
@@ -294,11 +493,19 @@ func (s *Session) onACK(surbID *[sConstants.SURBIDLength]byte, ciphertext []byte
 		s.decrementDecoyLoopTally()
 		return nil
 	}
+	s.serviceStats.onReply(msg.Recipient, msg.Provider, s.clock.Now().Sub(msg.SentAt), len(plaintext[2:]))
 	if msg.Reliable {
 		err := s.rescheduler.timerQ.Remove(msg)
 		if err != nil {
-			s.fatalErrCh <- fmt.Errorf("Failed removing reliable message from retransmit queue")
+			s.recovered("arq", fmt.Errorf("failed removing reliable message from retransmit queue: %v", err))
+		}
+	}
+	if msg.group != nil {
+		if !msg.group.reply() {
+			s.log.Debugf("Discarding reply to a redundant copy of message %x", *msg.ID)
+			return nil
 		}
+		s.arqDelete(msg)
 	}
 	if msg.IsBlocking {
 		replyWaitChanRaw, ok := s.replyWaitChanMap.Load(*msg.ID)
@@ -326,6 +533,45 @@ func (s *Session) onACK(surbID *[sConstants.SURBIDLength]byte, ciphertext []byte
 	return nil
 }
 
+// CheckMail forces an immediate fetch from the Provider's receive queue
+// instead of waiting for the next scheduled poll.  The Provider does not
+// signal the end of a fetch, so the fetch is taken to be complete once
+// nothing has been received for CheckMailIdle, or when ctx is done.  It
+// returns the number of messages and replies received meanwhile, which
+// may include some fetched by a scheduled poll.  Fetches are rate
+// limited to one per CheckMailCooldown.
+func (s *Session) CheckMail(ctx context.Context) (int, error) {
+	s.mustBeInitialized()
+	s.checkMailLock.Lock()
+	now := s.clock.Now()
+	if now.Sub(s.lastCheckMail) < cConstants.CheckMailCooldown {
+		s.checkMailLock.Unlock()
+		return 0, ErrCheckMailCooldown
+	}
+	s.lastCheckMail = now
+	s.checkMailLock.Unlock()
+
+	before := atomic.LoadUint64(&s.receivedCount)
+	s.minclient.ForceFetch()
+	timer := s.clock.NewTimer(cConstants.CheckMailIdle)
+	defer timer.Stop()
+	last := before
+	for {
+		select {
+		case <-ctx.Done():
+		case <-s.HaltCh():
+		case <-timer.C():
+			if received := atomic.LoadUint64(&s.receivedCount); received != last {
+				last = received
+				timer.Reset(cConstants.CheckMailIdle)
+				continue
+			}
+		}
+		break
+	}
+	return int(atomic.LoadUint64(&s.receivedCount) - before), nil
+}
+
 func (s *Session) onDocument(doc *pki.Document) {
 	s.log.Debugf("onDocument(): Epoch %v", doc.Epoch)
 	s.hasPKIDoc = true
@@ -341,6 +587,14 @@ func (s *Session) CurrentDocument() *pki.Document {
 	return s.minclient.CurrentDocument()
 }
 
+// CurrentDocumentBytes returns the serialized PKI document for the
+// current epoch, suitable for saving and later use with WithPKISeed.
+func (s *Session) CurrentDocumentBytes(ctx context.Context) ([]byte, error) {
+	epoch, _, _ := epochtime.Now()
+	_, raw, err := s.pkiCacheClient.Get(ctx, epoch)
+	return raw, err
+}
+
 func (s *Session) GetReunionConfig() *config.Reunion {
 	return s.cfg.Reunion
 }
@@ -349,9 +603,57 @@ func (s *Session) GetPandaConfig() *config.Panda {
 	return s.cfg.Panda
 }
 
+// Close gracefully shuts down the Session.  New sends are refused while
+// the egress queue drains and the replies to messages already sent are
+// awaited, until ctx is done, after which the Session is shut down.
+func (s *Session) Close(ctx context.Context) error {
+	s.mustBeInitialized()
+	atomic.StoreUint32(&s.closing, 1)
+	timer := s.clock.NewTimer(closePollInterval)
+	defer timer.Stop()
+	var err error
+	for egressQueueLen(s.egressQueue) != 0 || s.pendingUserReplies() != 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-s.HaltCh():
+			err = ErrSessionHalted
+		case <-timer.C():
+			timer.Reset(closePollInterval)
+			continue
+		}
+		break
+	}
+	s.Shutdown()
+	return err
+}
+
+func (s *Session) pendingUserReplies() int {
+	n := s.retransmitsPending()
+	s.surbIDMap.Range(func(msg *Message) {
+		if !msg.IsDecoy {
+			n++
+		}
+	})
+	return n
+}
+
+// Shutdown immediately shuts down the Session.
 func (s *Session) Shutdown() {
-	s.Halt()
-	s.rescheduler.timerQ.Halt()
-	s.minclient.Shutdown()
-	s.minclient.Wait()
+	s.mustBeInitialized()
+	s.shutdownOnce.Do(func() {
+		s.Halt()
+		s.rescheduler.timerQ.Halt()
+		s.minclient.Shutdown()
+		s.minclient.Wait()
+		if s.ownsPKICache {
+			s.pkiCacheClient.Halt()
+		}
+		s.surbIDMap.Range(func(msg *Message) {
+			msg.wipe()
+		})
+		for m := s.popRetransmit(); m != nil; m = s.popRetransmit() {
+			m.wipe()
+		}
+	})
 }
@@ -0,0 +1,242 @@
+// session_test.go - mixnet client session tests
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cConstants "github.com/katzenpost/client/constants"
+	"github.com/katzenpost/client/internal/pkiclient"
+	"github.com/katzenpost/core/crypto/eddsa"
+	"github.com/katzenpost/core/pki"
+	sConstants "github.com/katzenpost/core/sphinx/constants"
+	"github.com/stretchr/testify/require"
+)
+
+// checkMail runs CheckMail, advancing the clock until it returns.
+func checkMail(ctx context.Context, s *Session, clock *ManualClock) (int, error) {
+	type result struct {
+		n   int
+		err error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		n, err := s.CheckMail(ctx)
+		resultCh <- result{n, err}
+	}()
+	for {
+		select {
+		case r := <-resultCh:
+			return r.n, r.err
+		default:
+			clock.Advance(cConstants.CheckMailIdle / 4)
+			runtime.Gosched()
+		}
+	}
+}
+
+func TestCheckMail(t *testing.T) {
+	require := require.New(t)
+
+	clock := NewManualClock(epoch0)
+	mc := new(fakeMinclient)
+	s := testSession(t, clock, mc)
+	defer haltTestSession(s)
+
+	// The fetch completes once nothing more arrives, without a deadline.
+	mc.onFetch = func() {
+		s.onMessage([]byte("message"))
+		s.onMessage([]byte("message"))
+	}
+	n, err := checkMail(context.Background(), s, clock)
+	require.NoError(err)
+	require.Equal(2, n)
+	require.Equal(1, mc.fetches)
+
+	// Fetches are rate limited.
+	_, err = s.CheckMail(context.Background())
+	require.Equal(ErrCheckMailCooldown, err)
+
+	// A done context ends the wait early.
+	clock.Advance(cConstants.CheckMailCooldown)
+	mc.onFetch = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err = s.CheckMail(ctx)
+	require.NoError(err)
+	require.Zero(n)
+}
+
+func TestClientCheckMail(t *testing.T) {
+	require := require.New(t)
+
+	clock := NewManualClock(epoch0)
+	s1 := testSession(t, clock, new(fakeMinclient))
+	defer haltTestSession(s1)
+	s2 := testSession(t, clock, new(fakeMinclient))
+	defer haltTestSession(s2)
+	c := &Client{
+		session:   s1,
+		ephemeral: map[*Session]*time.Timer{s2: nil},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// One account in its cooldown does not fail the others.
+	s1.lastCheckMail = clock.Now()
+	_, err := c.CheckMail(ctx)
+	require.NoError(err)
+
+	// Only if no account could fetch is an error returned.
+	_, err = c.CheckMail(ctx)
+	require.Equal(ErrCheckMailCooldown, err)
+}
+
+func TestClientQueueStats(t *testing.T) {
+	require := require.New(t)
+
+	clock := NewManualClock(epoch0)
+	c := &Client{ephemeral: map[*Session]*time.Timer{}}
+	_, err := c.QueueStats("")
+	require.Equal(ErrNoSession, err)
+
+	s1 := testSession(t, clock, new(fakeMinclient))
+	defer haltTestSession(s1)
+	s2 := testSession(t, clock, new(fakeMinclient))
+	defer haltTestSession(s2)
+	s2.egressQueue = &lenlessQueue{}
+	require.NoError(s2.egressQueue.Push(testMessage(1, "bob")))
+	c.session = s1
+	c.ephemeral[s2] = nil
+
+	stats, err := c.QueueStats("")
+	require.NoError(err)
+	require.Zero(stats.Pending)
+	stats, err = c.QueueStats(s2.Info().LinkKeyFingerprint)
+	require.NoError(err)
+	require.Equal(1, stats.Pending)
+	_, err = c.QueueStats("unknown")
+	require.Equal(ErrUnknownAccount, err)
+
+	all := c.AllQueueStats()
+	require.Len(all, 2)
+	require.Equal(1, all[s2.Info().LinkKeyFingerprint].Pending)
+}
+
+var errNoDocument = errors.New("no document")
+
+// nullPKI is a pki.Client without any documents.
+type nullPKI struct{}
+
+func (nullPKI) Get(ctx context.Context, epoch uint64) (*pki.Document, []byte, error) {
+	return nil, nil, errNoDocument
+}
+
+func (nullPKI) Post(ctx context.Context, epoch uint64, signingKey *eddsa.PrivateKey, d *pki.MixDescriptor) error {
+	return errNoDocument
+}
+
+func (nullPKI) Deserialize(raw []byte) (*pki.Document, error) {
+	return nil, errNoDocument
+}
+
+func TestSharedPKICache(t *testing.T) {
+	require := require.New(t)
+
+	shared := pkiclient.New(nullPKI{})
+	cache, owned := pkiCache(shared)
+	require.True(cache == shared)
+	require.False(owned)
+	own, owned := pkiCache(nullPKI{})
+	require.True(owned)
+
+	// A session only halts the cache it owns.
+	clock := NewManualClock(epoch0)
+	s1 := testSession(t, clock, new(fakeMinclient))
+	s1.pkiCacheClient = shared
+	s1.Shutdown()
+	s2 := testSession(t, clock, new(fakeMinclient))
+	s2.pkiCacheClient, s2.ownsPKICache = own, true
+	s2.Shutdown()
+	<-own.HaltCh()
+	_, _, err := shared.Get(context.Background(), 1)
+	require.Equal(errNoDocument, err)
+
+	// The Client halts the shared cache once its sessions are gone.
+	c := &Client{
+		log:        s1.log,
+		fatalErrCh: make(chan error),
+		haltedCh:   make(chan interface{}),
+		ephemeral:  map[*Session]*time.Timer{},
+		pkiClient:  shared,
+	}
+	c.halt()
+	<-shared.HaltCh()
+}
+
+func TestSessionClose(t *testing.T) {
+	require := require.New(t)
+
+	// Close waits for the egress queue to drain, refusing new sends.
+	clock := NewManualClock(epoch0)
+	s := testSession(t, clock, new(fakeMinclient))
+	require.NoError(s.egressQueue.Push(testMessage(1, "bob")))
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Close(context.Background())
+	}()
+	require.Eventually(func() bool {
+		return atomic.LoadUint32(&s.closing) != 0
+	}, time.Second, time.Millisecond)
+	_, err := s.SendMessage("bob", "acme", []byte("hello"))
+	require.Equal(ErrSessionClosing, err)
+	clock.Advance(closePollInterval)
+	require.Equal(1, egressQueueLen(s.egressQueue))
+	_, err = s.egressQueue.Pop()
+	require.NoError(err)
+	for done := false; !done; {
+		select {
+		case err = <-errCh:
+			done = true
+		default:
+			clock.Advance(closePollInterval)
+			runtime.Gosched()
+		}
+	}
+	require.NoError(err)
+	<-s.HaltCh()
+
+	// Close gives up on replies which have not arrived when ctx is done.
+	s = testSession(t, clock, new(fakeMinclient))
+	msg := testMessage(2, "bob")
+	msg.Key = []byte("key")
+	require.NoError(s.surbIDMap.Store([sConstants.SURBIDLength]byte{2}, msg))
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		errCh <- s.Close(ctx)
+	}()
+	clock.Advance(closePollInterval)
+	cancel()
+	require.Equal(context.Canceled, <-errCh)
+	<-s.HaltCh()
+	require.Nil(msg.Key)
+}
@@ -0,0 +1,140 @@
+// stats.go - mixnet client session statistics
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"time"
+
+	"github.com/katzenpost/core/constants"
+)
+
+// QueueStats describes the outbound state of a Session.
+type QueueStats struct {
+	// Pending is the number of messages waiting in the egress queue.
+	Pending int
+
+	// Bytes is the total payload size of the messages waiting in the
+	// egress queue.
+	Bytes int
+
+	// OldestAge is how long the message at the head of the egress
+	// queue has been waiting to be sent.
+	OldestAge time.Duration
+
+	// Rejected is the number of messages refused by the full egress
+	// queue, and Evicted the number dropped from it to make room.
+	Rejected uint64
+	Evicted  uint64
+
+	// PendingRetransmits is the number of unanswered messages waiting
+	// for a LambdaP event to be retransmitted.
+	PendingRetransmits int
+
+	// AwaitingReply is the number of sent messages awaiting a SURB reply.
+	AwaitingReply int
+
+	// Retransmissions is the total number of retransmissions of the
+	// messages awaiting a SURB reply.
+	Retransmissions uint64
+
+	// ExpiredReplies is the number of sent messages whose SURB reply
+	// never arrived.
+	ExpiredReplies uint64
+}
+
+// QueueStats returns the current outbound queue statistics.  If the
+// egress queue has no Len method, Pending is at most 1.
+func (s *Session) QueueStats() *QueueStats {
+	stats := &QueueStats{
+		Pending:            egressQueueLen(s.egressQueue),
+		PendingRetransmits: s.retransmitsPending(),
+		AwaitingReply:      s.surbIDMap.Len(),
+		ExpiredReplies:     s.surbIDMap.Expired(),
+	}
+	// Every queued payload is padded to the same length.
+	stats.Bytes = stats.Pending * constants.UserForwardPayloadLength
+	if item, err := s.egressQueue.Peek(); err == nil {
+		if msg, ok := item.(*Message); ok && !msg.QueuedAt.IsZero() {
+			stats.OldestAge = s.clock.Now().Sub(msg.QueuedAt)
+		}
+	}
+	if q, ok := s.egressQueue.(*Queue); ok {
+		metrics := q.Metrics()
+		stats.Rejected = metrics.Rejected
+		stats.Evicted = metrics.Evicted
+	}
+	s.surbIDMap.Range(func(msg *Message) {
+		stats.Retransmissions += uint64(msg.Retransmissions)
+	})
+	return stats
+}
+
+// ServiceStats returns the statistics of the messages sent to the
+// given service, or nil if none have been sent.
+func (s *Session) ServiceStats(recipient, provider string) *ServiceStats {
+	return s.serviceStats.Stats(recipient, provider)
+}
+
+// AllServiceStats returns the statistics of every service messages
+// have been sent to, keyed by recipient@provider.
+func (s *Session) AllServiceStats() map[string]*ServiceStats {
+	return s.serviceStats.All()
+}
+
+// ProviderStats returns the statistics of the messages sent to all of
+// the Provider's services, or nil if none have been sent.
+func (s *Session) ProviderStats(provider string) *ProviderStats {
+	stats := s.serviceStats.Provider(provider)
+	if stats != nil {
+		stats.SRTT, _ = s.rtt.SRTT(provider)
+	}
+	return stats
+}
+
+// AllProviderStats returns the statistics of every Provider messages
+// have been sent to, keyed by Provider name.
+func (s *Session) AllProviderStats() map[string]*ProviderStats {
+	all := make(map[string]*ProviderStats)
+	for _, provider := range s.serviceStats.Providers() {
+		all[provider] = s.ProviderStats(provider)
+	}
+	return all
+}
+
+// recordFailure records a message whose reply never arrived, warning
+// when the destination Provider starts to consistently fail.
+func (s *Session) recordFailure(recipient, provider string) {
+	if s.serviceStats.onFailure(recipient, provider, s.clock.Now()) == providerFailingThreshold {
+		s.log.Warningf("Provider %v has failed %d times in a row", provider, providerFailingThreshold)
+		s.eventCh.In() <- &ProviderFailingEvent{
+			Provider:            provider,
+			ConsecutiveFailures: providerFailingThreshold,
+		}
+	}
+}
+
+// isProviderFailing returns true if the Provider is consistently failing
+// and has failed within providerRetryInterval.  Once that has passed the
+// Provider is tried again, and it is either reset by a reply or avoided
+// for another interval by a failure.
+func (s *Session) isProviderFailing(provider string) bool {
+	stats := s.serviceStats.Provider(provider)
+	if stats == nil || !stats.IsFailing() {
+		return false
+	}
+	return s.clock.Now().Sub(stats.LastFailure) < providerRetryInterval
+}
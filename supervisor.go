@@ -0,0 +1,126 @@
+// supervisor.go - Error classification and supervision.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// supervisorMaxRestarts is the number of consecutive restarts of a
+	// component after which its error is treated as fatal.
+	supervisorMaxRestarts = 5
+
+	// supervisorBaseDelay and supervisorMaxDelay bound the backoff
+	// between restarts of a component.
+	supervisorBaseDelay = time.Second
+	supervisorMaxDelay  = time.Minute
+
+	// supervisorResetAfter is how long a restarted component must run
+	// before its restart count is reset.
+	supervisorResetAfter = 10 * time.Minute
+)
+
+// ComponentError is an error of a component of the Session, classified
+// as recoverable or fatal.  Errors which are not ComponentErrors are
+// fatal.
+type ComponentError struct {
+	// Component is the name of the component which failed.
+	Component string
+
+	// Recoverable is true if the component can be restarted, or has
+	// already recovered, without shutting down the Client.
+	Recoverable bool
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns the error string of a ComponentError.
+func (e *ComponentError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Component, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ComponentError) Unwrap() error {
+	return e.Err
+}
+
+// recoverable classifies err of the named component as recoverable.
+func recoverable(component string, err error) error {
+	return &ComponentError{Component: component, Recoverable: true, Err: err}
+}
+
+// IsRecoverable returns true if err is a recoverable ComponentError.
+func IsRecoverable(err error) bool {
+	var e *ComponentError
+	return errors.As(err, &e) && e.Recoverable
+}
+
+// fatal reports err as fatal, which shuts down the Client, or only the
+// Session if it is an ephemeral account.  Nothing is reported once the
+// Session has halted.
+func (s *Session) fatal(err error) {
+	s.eventCh.In() <- &ErrorEvent{Err: err, Fatal: true}
+	select {
+	case s.fatalErrCh <- err:
+	case <-s.HaltCh():
+	}
+}
+
+// recovered reports a recoverable error of the named component, which has
+// already recovered from it.
+func (s *Session) recovered(component string, err error) {
+	s.log.Warningf("Recovered from %v error: %v", component, err)
+	s.eventCh.In() <- &ErrorEvent{Component: component, Err: err}
+}
+
+// supervise runs the named component until the Session halts, restarting
+// it with exponential backoff when it fails with a recoverable error.  A
+// fatal error, or too many consecutive restarts, is reported as fatal.
+func (s *Session) supervise(component string, fn func() error) {
+	restarts := uint32(0)
+	for {
+		startedAt := s.clock.Now()
+		err := fn()
+		if err == nil {
+			return
+		}
+		if s.clock.Now().Sub(startedAt) >= supervisorResetAfter {
+			restarts = 0
+		}
+		if !IsRecoverable(err) || restarts >= supervisorMaxRestarts {
+			s.log.Errorf("Component %v failed: %v", component, err)
+			s.fatal(err)
+			return
+		}
+		restarts++
+		delay := backoff(supervisorBaseDelay, restarts-1, 2, supervisorMaxDelay)
+		s.log.Warningf("Restarting %v in %v after error: %v", component, delay, err)
+		s.eventCh.In() <- &ErrorEvent{Component: component, Err: err, Restarts: int(restarts)}
+
+		timer := s.clock.NewTimer(delay)
+		select {
+		case <-s.HaltCh():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// supervisor_test.go - Error classification and supervision tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/core/log"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/eapache/channels.v1"
+)
+
+func supervisorSession(t *testing.T, clock *ManualClock) *Session {
+	logBackend, err := log.New("", "ERROR", true)
+	require.NoError(t, err)
+	return &Session{
+		log:        logBackend.GetLogger("supervisor"),
+		clock:      clock,
+		fatalErrCh: make(chan error, 1),
+		eventCh:    channels.NewInfiniteChannel(),
+	}
+}
+
+func TestIsRecoverable(t *testing.T) {
+	require := require.New(t)
+
+	err := recoverable("worker", errors.New("PKI doc is nil"))
+	require.True(IsRecoverable(err))
+	require.True(IsRecoverable(fmt.Errorf("wrapped: %w", err)))
+	require.Equal("worker: PKI doc is nil", err.Error())
+	require.False(IsRecoverable(errors.New("impossible failure")))
+	require.False(IsRecoverable(&ComponentError{Component: "worker", Err: errors.New("bad")}))
+}
+
+func TestSupervise(t *testing.T) {
+	require := require.New(t)
+
+	clock := NewManualClock(time.Unix(0, 0))
+	s := supervisorSession(t, clock)
+	defer s.Halt()
+
+	// A clean exit is not restarted.
+	runs := 0
+	s.supervise("clean", func() error {
+		runs++
+		return nil
+	})
+	require.Equal(1, runs)
+
+	// A fatal error is reported at once.
+	fatalErr := errors.New("impossible failure")
+	s.supervise("fatal", func() error {
+		return fatalErr
+	})
+	require.Equal(fatalErr, <-s.fatalErrCh)
+	require.True((<-s.eventCh.Out()).(*ErrorEvent).Fatal)
+
+	// A recoverable error is restarted with backoff until it has
+	// happened too many times in a row.
+	go s.supervise("worker", func() error {
+		return recoverable("worker", errors.New("PKI doc is nil"))
+	})
+	restarts := 0
+	for {
+		select {
+		case e := <-s.eventCh.Out():
+			event := e.(*ErrorEvent)
+			if event.Fatal {
+				require.Equal(supervisorMaxRestarts, restarts)
+				require.True(IsRecoverable(<-s.fatalErrCh))
+				return
+			}
+			restarts++
+			require.Equal("worker", event.Component)
+			require.Equal(restarts, event.Restarts)
+		default:
+			clock.Advance(supervisorMaxDelay)
+			runtime.Gosched()
+		}
+	}
+}
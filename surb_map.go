@@ -0,0 +1,193 @@
+// surb_map.go - SURB ID to message map.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	sConstants "github.com/katzenpost/core/sphinx/constants"
+)
+
+// ErrTooManyPendingReplies is the error issued when the number of
+// messages awaiting a SURB reply has reached the configured bound.
+var ErrTooManyPendingReplies = errors.New("too many messages awaiting replies")
+
+// ErrSURBIDReused is the error issued when a SURB ID which has already
+// been issued is used again.
+var ErrSURBIDReused = errors.New("SURB ID has already been used")
+
+// consumedPerPending is the number of consumed SURB IDs remembered per
+// message which may be awaiting a reply.
+const consumedPerPending = 16
+
+// surbMap is a bounded map of SURB IDs to the messages awaiting
+// a reply to that SURB.  It also remembers the SURB IDs which have
+// been answered or expired, so that each SURB ID is only used once.
+// At most consumedPerPending times as many consumed SURB IDs as
+// pending replies are remembered, forgetting the oldest first.
+type surbMap struct {
+	sync.Mutex
+
+	m             map[[sConstants.SURBIDLength]byte]*Message
+	consumed      map[[sConstants.SURBIDLength]byte]time.Time
+	consumedOrder [][sConstants.SURBIDLength]byte
+	maxSize       int
+	expired       uint64
+}
+
+func newSURBMap(maxSize int) *surbMap {
+	return &surbMap{
+		m:        make(map[[sConstants.SURBIDLength]byte]*Message),
+		consumed: make(map[[sConstants.SURBIDLength]byte]time.Time),
+		maxSize:  maxSize,
+	}
+}
+
+// consume removes the SURB ID and remembers it as consumed at now.
+func (m *surbMap) consume(surbID [sConstants.SURBIDLength]byte, now time.Time) {
+	delete(m.m, surbID)
+	m.consumed[surbID] = now
+	m.consumedOrder = append(m.consumedOrder, surbID)
+	for len(m.consumedOrder) > consumedPerPending*m.maxSize {
+		delete(m.consumed, m.consumedOrder[0])
+		m.consumedOrder = m.consumedOrder[1:]
+	}
+}
+
+// CheckUnused returns ErrSURBIDReused if the SURB ID has been issued before.
+func (m *surbMap) CheckUnused(surbID [sConstants.SURBIDLength]byte) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.checkUnused(surbID)
+}
+
+func (m *surbMap) checkUnused(surbID [sConstants.SURBIDLength]byte) error {
+	if _, ok := m.m[surbID]; ok {
+		return ErrSURBIDReused
+	}
+	if _, ok := m.consumed[surbID]; ok {
+		return ErrSURBIDReused
+	}
+	return nil
+}
+
+// Store adds the message to the map, returning ErrTooManyPendingReplies
+// if the map is full or ErrSURBIDReused if the SURB ID was issued before.
+func (m *surbMap) Store(surbID [sConstants.SURBIDLength]byte, msg *Message) error {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.checkUnused(surbID); err != nil {
+		return err
+	}
+	if len(m.m) >= m.maxSize {
+		return ErrTooManyPendingReplies
+	}
+	m.m[surbID] = msg
+	return nil
+}
+
+// IsConsumed returns true if a reply for the SURB ID was already
+// received, or the SURB ID expired without a reply.
+func (m *surbMap) IsConsumed(surbID [sConstants.SURBIDLength]byte) bool {
+	m.Lock()
+	defer m.Unlock()
+	_, ok := m.consumed[surbID]
+	return ok
+}
+
+// ExpireConsumed forgets the SURB IDs consumed before the given time,
+// which must be long enough ago that the SURBs can no longer be used.
+// SURB IDs are consumed in time order, so only the oldest are checked.
+func (m *surbMap) ExpireConsumed(before time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	for len(m.consumedOrder) != 0 && m.consumed[m.consumedOrder[0]].Before(before) {
+		delete(m.consumed, m.consumedOrder[0])
+		m.consumedOrder = m.consumedOrder[1:]
+	}
+}
+
+// Load returns the message stored for the SURB ID if any.
+func (m *surbMap) Load(surbID [sConstants.SURBIDLength]byte) (*Message, bool) {
+	m.Lock()
+	defer m.Unlock()
+	msg, ok := m.m[surbID]
+	return msg, ok
+}
+
+// LoadAndDelete removes the message stored for the SURB ID, marking
+// the SURB ID as consumed at now, and returns it if it was present.
+func (m *surbMap) LoadAndDelete(surbID [sConstants.SURBIDLength]byte, now time.Time) (*Message, bool) {
+	m.Lock()
+	defer m.Unlock()
+	msg, ok := m.m[surbID]
+	if ok {
+		m.consume(surbID, now)
+	}
+	return msg, ok
+}
+
+// Len returns the number of messages awaiting a reply.
+func (m *surbMap) Len() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.m)
+}
+
+// IsFull returns true if no more messages can be stored.
+func (m *surbMap) IsFull() bool {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.m) >= m.maxSize
+}
+
+// Range calls fn for each message awaiting a reply.
+func (m *surbMap) Range(fn func(*Message)) {
+	m.Lock()
+	defer m.Unlock()
+	for _, msg := range m.m {
+		fn(msg)
+	}
+}
+
+// Expire removes and returns every message whose reply has not arrived
+// within ReplyETA, its path ETA and slop of it being sent.  A reliable
+// message is retransmitted once its path ETA has passed, so it is only
+// expired if its retransmission timer has not removed it by then.
+func (m *surbMap) Expire(now time.Time, slop time.Duration) []*Message {
+	m.Lock()
+	defer m.Unlock()
+	expired := []*Message{}
+	for surbID, msg := range m.m {
+		if now.After(msg.SentAt.Add(msg.ReplyETA).Add(msg.pathETA).Add(slop)) {
+			m.consume(surbID, now)
+			expired = append(expired, msg)
+		}
+	}
+	m.expired += uint64(len(expired))
+	return expired
+}
+
+// Expired returns the total number of messages that were expired
+// while awaiting a reply.
+func (m *surbMap) Expired() uint64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.expired
+}
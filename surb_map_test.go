@@ -0,0 +1,106 @@
+// surb_map_test.go - SURB ID to message map tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	sConstants "github.com/katzenpost/core/sphinx/constants"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSURBMapBound(t *testing.T) {
+	assert := assert.New(t)
+
+	m := newSURBMap(2)
+	assert.NoError(m.Store([sConstants.SURBIDLength]byte{1}, &Message{}))
+	assert.NoError(m.Store([sConstants.SURBIDLength]byte{2}, &Message{}))
+	assert.True(m.IsFull())
+	err := m.Store([sConstants.SURBIDLength]byte{3}, &Message{})
+	assert.Equal(ErrTooManyPendingReplies, err)
+
+	_, ok := m.LoadAndDelete([sConstants.SURBIDLength]byte{1}, time.Now())
+	assert.True(ok)
+	_, ok = m.LoadAndDelete([sConstants.SURBIDLength]byte{1}, time.Now())
+	assert.False(ok)
+	assert.Equal(1, m.Len())
+	assert.NoError(m.Store([sConstants.SURBIDLength]byte{3}, &Message{}))
+}
+
+func TestSURBMapSingleUse(t *testing.T) {
+	assert := assert.New(t)
+
+	m := newSURBMap(10)
+	surbID := [sConstants.SURBIDLength]byte{1}
+	assert.NoError(m.CheckUnused(surbID))
+	assert.NoError(m.Store(surbID, &Message{}))
+	assert.Equal(ErrSURBIDReused, m.CheckUnused(surbID))
+	assert.Equal(ErrSURBIDReused, m.Store(surbID, &Message{}))
+
+	_, ok := m.LoadAndDelete(surbID, time.Now())
+	assert.True(ok)
+	assert.True(m.IsConsumed(surbID))
+	assert.Equal(ErrSURBIDReused, m.Store(surbID, &Message{}))
+
+	m.ExpireConsumed(time.Now().Add(time.Minute))
+	assert.False(m.IsConsumed(surbID))
+	assert.NoError(m.CheckUnused(surbID))
+}
+
+func TestSURBMapExpire(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	m := newSURBMap(10)
+	stale := &Message{SentAt: now.Add(-2 * time.Minute), ReplyETA: time.Minute}
+	fresh := &Message{SentAt: now, ReplyETA: time.Minute}
+	assert.NoError(m.Store([sConstants.SURBIDLength]byte{1}, stale))
+	assert.NoError(m.Store([sConstants.SURBIDLength]byte{2}, fresh))
+
+	expired := m.Expire(now, 30*time.Second)
+	assert.Equal([]*Message{stale}, expired)
+	assert.Equal(1, m.Len())
+	assert.Equal(uint64(1), m.Expired())
+
+	_, ok := m.Load([sConstants.SURBIDLength]byte{2})
+	assert.True(ok)
+	assert.True(m.IsConsumed([sConstants.SURBIDLength]byte{1}))
+}
+
+func TestSURBMapConsumedBound(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	m := newSURBMap(1)
+	for i := 0; i <= consumedPerPending; i++ {
+		surbID := [sConstants.SURBIDLength]byte{byte(i)}
+		assert.NoError(m.Store(surbID, &Message{}))
+		_, ok := m.LoadAndDelete(surbID, now.Add(time.Duration(i)*time.Second))
+		assert.True(ok)
+	}
+
+	// The oldest consumed SURB ID is forgotten first.
+	assert.False(m.IsConsumed([sConstants.SURBIDLength]byte{0}))
+	for i := 1; i <= consumedPerPending; i++ {
+		assert.True(m.IsConsumed([sConstants.SURBIDLength]byte{byte(i)}))
+	}
+
+	m.ExpireConsumed(now.Add(consumedPerPending * time.Second))
+	assert.False(m.IsConsumed([sConstants.SURBIDLength]byte{consumedPerPending - 1}))
+	assert.True(m.IsConsumed([sConstants.SURBIDLength]byte{consumedPerPending}))
+}
@@ -43,15 +43,19 @@ type TimerQueue struct {
 	priq  *queue.PriorityQueue
 	nextQ nqueue
 
-	timer  *time.Timer
+	clock  TimerClock
 	wakech chan struct{}
 }
 
 // NewTimerQueue intantiates a new TimerQueue and starts the worker routine
 func NewTimerQueue(nextQueue nqueue) *TimerQueue {
+	return newTimerQueue(nextQueue, systemClock{})
+}
+
+func newTimerQueue(nextQueue nqueue, clock TimerClock) *TimerQueue {
 	a := &TimerQueue{
 		nextQ: nextQueue,
-		timer: time.NewTimer(0),
+		clock: clock,
 		priq:  queue.New(),
 	}
 	a.L = new(sync.Mutex)
@@ -129,16 +133,19 @@ func (a *TimerQueue) forward() {
 func (a *TimerQueue) worker() {
 	for {
 		var c <-chan time.Time
+		var timer Timer
 		a.Lock()
 		if m := a.priq.Peek(); m != nil {
 			// Figure out if the message needs to be handled now.
-			timeLeft := int64(m.Priority) - time.Now().UnixNano()
-			if timeLeft < 0 || m.Priority < uint64(time.Now().UnixNano()) {
+			now := a.clock.Now().UnixNano()
+			timeLeft := int64(m.Priority) - now
+			if timeLeft < 0 || m.Priority < uint64(now) {
 				a.Unlock()
 				a.forward()
 				continue
 			} else {
-				c = time.After(time.Duration(timeLeft))
+				timer = a.clock.NewTimer(time.Duration(timeLeft))
+				c = timer.C()
 			}
 		}
 		a.Unlock()
@@ -150,5 +157,8 @@ func (a *TimerQueue) worker() {
 			a.forward()
 		case <-a.wakeupCh():
 		}
+		if timer != nil {
+			timer.Stop()
+		}
 	}
 }
@@ -0,0 +1,75 @@
+// validate.go - Constructor argument validation.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"errors"
+
+	"github.com/katzenpost/client/config"
+	"github.com/katzenpost/core/crypto/ecdh"
+	"github.com/katzenpost/core/log"
+)
+
+// ErrInvalidConfig is the error issued when a Client or Session is
+// created with a configuration that has not been validated.
+var ErrInvalidConfig = errors.New("configuration must be loaded with config.Load or validated with FixupAndValidate")
+
+// ErrNoAccount is the error issued when a Session is created with a
+// configuration lacking the Account section.
+var ErrNoAccount = errors.New("configuration has no Account")
+
+// checkConfig returns ErrInvalidConfig unless cfg has been validated.
+func checkConfig(cfg *config.Config) error {
+	if cfg == nil || cfg.Logging == nil || cfg.Debug == nil || cfg.UpstreamProxyConfig() == nil {
+		return ErrInvalidConfig
+	}
+	return nil
+}
+
+// checkSessionArgs validates the arguments of NewSession.
+func checkSessionArgs(fatalErrCh chan error, logBackend *log.Backend, cfg *config.Config, linkKey *ecdh.PrivateKey) error {
+	if err := checkConfig(cfg); err != nil {
+		return err
+	}
+	switch {
+	case cfg.Account == nil:
+		return ErrNoAccount
+	case fatalErrCh == nil:
+		return errors.New("NewSession requires a fatal error channel")
+	case logBackend == nil:
+		return errors.New("NewSession requires a log backend")
+	case linkKey == nil:
+		return errors.New("NewSession requires a link key")
+	}
+	return nil
+}
+
+// mustBeInitialized panics if the Client was not created by New, rather
+// than failing obscurely later.
+func (c *Client) mustBeInitialized() {
+	if c.haltOnce == nil {
+		panic("client: Client used without being created by New")
+	}
+}
+
+// mustBeInitialized panics if the Session was not created by NewSession,
+// rather than failing obscurely later.
+func (s *Session) mustBeInitialized() {
+	if s.log == nil {
+		panic("client: Session used without being created by NewSession")
+	}
+}
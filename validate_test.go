@@ -0,0 +1,41 @@
+// validate_test.go - Constructor argument validation tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/katzenpost/client/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConstructorValidation(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := New(nil)
+	assert.Equal(ErrInvalidConfig, err)
+	_, err = New(&config.Config{})
+	assert.Equal(ErrInvalidConfig, err)
+
+	_, err = NewSession(context.Background(), make(chan error), nil, nil, nil)
+	assert.Equal(ErrInvalidConfig, err)
+
+	assert.Panics(func() { new(Client).Shutdown() })
+	assert.Panics(func() { new(Session).Shutdown() })
+	assert.Panics(func() { new(Session).SendUnreliableMessage("alice", "acme", []byte("hello")) })
+}
@@ -19,6 +19,7 @@ package client
 import (
 	"errors"
 	"math"
+	"sync/atomic"
 	"time"
 
 	"github.com/katzenpost/client/constants"
@@ -26,7 +27,6 @@ import (
 	"github.com/katzenpost/client/utils"
 	"github.com/katzenpost/core/crypto/rand"
 	"github.com/katzenpost/core/pki"
-	mrand "math/rand"
 )
 
 type workerOp interface{}
@@ -39,14 +39,10 @@ type opNewDocument struct {
 	doc *pki.Document
 }
 
-type opRetransmit struct {
-	msg *Message
-}
-
 func (s *Session) connStatusChange(op opConnStatusChanged) bool {
 	isConnected := op.isConnected
 	if isConnected {
-		s.onlineAt = time.Now()
+		s.onlineAt = s.clock.Now()
 
 		skew := s.minclient.ClockSkew()
 		absSkew := skew
@@ -64,26 +60,22 @@ func (s *Session) connStatusChange(op opConnStatusChanged) bool {
 	return isConnected
 }
 
-func (s *Session) worker() {
+func (s *Session) worker() error {
 	const maxDuration = math.MaxInt64
-	mRng := rand.NewMath()
+	mRng := s.scheduleRng
+	if mRng == nil {
+		mRng = rand.NewMath()
+	}
 	// The PKI doc should be cached since we've
 	// already waited until we received it.
 	doc := s.minclient.CurrentDocument()
 	if doc == nil {
-		s.fatalErrCh <- errors.New("aborting, PKI doc is nil")
-		return
+		return recoverable("worker", errors.New("PKI doc is nil"))
 	}
 
-	// get the initial loop services if decoy traffic is enabled
-	var loopServices []utils.ServiceDescriptor
-	if !s.cfg.Debug.DisableDecoyTraffic {
-		loopServices = utils.FindServices(cConstants.LoopService, doc)
-		if len(loopServices) == 0 {
-			s.fatalErrCh <- errors.New("failure to get loop service")
-			return
-		}
-	}
+	// get the initial loop services, if there are none the document
+	// policy has disabled decoy traffic
+	loopServices := utils.FindServices(cConstants.LoopService, doc)
 
 	// LambdaP timer setup
 	lambdaP := doc.LambdaP
@@ -92,7 +84,7 @@ func (s *Session) worker() {
 		lambdaPMsec = doc.LambdaPMaxDelay
 	}
 	lambdaPInterval := time.Duration(lambdaPMsec) * time.Millisecond
-	lambdaPTimer := time.NewTimer(lambdaPInterval)
+	lambdaPTimer := s.clock.NewTimer(lambdaPInterval)
 	defer lambdaPTimer.Stop()
 
 	// LambdaL timer setup
@@ -102,8 +94,6 @@ func (s *Session) worker() {
 		lambdaLMsec = doc.LambdaLMaxDelay
 	}
 	lambdaLInterval := time.Duration(lambdaLMsec) * time.Millisecond
-	lambdaLTimer := time.NewTimer(lambdaLInterval)
-	defer lambdaLTimer.Stop()
 
 	// LambdaD timer setup
 	lambdaD := doc.LambdaD
@@ -112,7 +102,13 @@ func (s *Session) worker() {
 		lambdaDMsec = doc.LambdaDMaxDelay
 	}
 	lambdaDInterval := time.Duration(lambdaDMsec) * time.Millisecond
-	lambdaDTimer := time.NewTimer(lambdaDInterval)
+	if s.decoysDisabled() || len(loopServices) == 0 {
+		lambdaLInterval = time.Duration(maxDuration)
+		lambdaDInterval = time.Duration(maxDuration)
+	}
+	lambdaLTimer := s.clock.NewTimer(lambdaLInterval)
+	defer lambdaLTimer.Stop()
+	lambdaDTimer := s.clock.NewTimer(lambdaDInterval)
 	defer lambdaDTimer.Stop()
 
 	defer s.log.Debug("session worker halted")
@@ -129,78 +125,77 @@ func (s *Session) worker() {
 		select {
 		case <-s.HaltCh():
 			s.log.Debugf("Session worker terminating gracefully.")
-			return
-		case <-lambdaPTimer.C:
+			return nil
+		case <-lambdaPTimer.C():
 			lambdaPFired = true
-		case <-lambdaLTimer.C:
+		case <-lambdaLTimer.C():
 			lambdaLFired = true
-		case <-lambdaDTimer.C:
+		case <-lambdaDTimer.C():
 			lambdaDFired = true
 		case qo = <-s.opCh:
 		}
 
+		// outside of the activity window nothing is sent
+		factor := s.activityFactor()
+		s.setDormant(factor == 0, doc)
+
 		if qo != nil {
 			switch op := qo.(type) {
-			case opRetransmit:
-				s.doRetransmit(op.msg)
 			case opConnStatusChanged:
 				newConnectedStatus := s.connStatusChange(op)
 				isConnected = newConnectedStatus
 				mustResetAllTimers = true
 			case opNewDocument:
-				err := s.isDocValid(op.doc)
+				err := s.checkDoc(op.doc)
 				if err != nil {
-					s.fatalErrCh <- err
+					// Keep using the last good document.
+					s.recovered("pki", err)
+					break
 				}
+				s.checkAccountProvider(op.doc)
 
 				doc = op.doc
-				s.setPollIntervalFromDoc(doc)
+				if !s.isDormant() {
+					s.setPollIntervalFromDoc(doc)
+				}
 				lambdaP = doc.LambdaP
 				lambdaL = doc.LambdaL
 				lambdaD = doc.LambdaD
 
 				// update the loop service descriptors
 				loopServices = utils.FindServices(cConstants.LoopService, doc)
-				if len(loopServices) == 0 {
-					s.fatalErrCh <- errors.New("failure to get loop service")
-					return
-				}
 
 				mustResetAllTimers = true
 			default:
 				s.log.Warningf("BUG: Worker received nonsensical op: %T", op)
 			} // end of switch
 		} else {
-			if isConnected {
+			if isConnected && factor != 0 {
 				// select a loop service endpoint
-				if !s.cfg.Debug.DisableDecoyTraffic {
-					loopSvc = &loopServices[mrand.Intn(len(loopServices))]
+				decoysDisabled := s.decoysDisabled() || len(loopServices) == 0
+				if !decoysDisabled {
+					loopSvc = s.decoyServiceFn(loopServices)
 				}
 				if lambdaPFired {
 					s.sendFromQueueOrDecoy(loopSvc)
-				} else if lambdaLFired && !s.cfg.Debug.DisableDecoyTraffic {
+				} else if lambdaLFired && loopSvc != nil {
 					s.sendLoopDecoy(loopSvc)
-				} else if lambdaDFired && !s.cfg.Debug.DisableDecoyTraffic {
+				} else if lambdaDFired && loopSvc != nil {
 					s.sendDropDecoy(loopSvc)
 				}
 			}
 		}
 		if isConnected {
-			lambdaPMsec := uint64(rand.Exp(mRng, lambdaP))
-			if lambdaPMsec > doc.LambdaPMaxDelay {
-				lambdaPMsec = doc.LambdaPMaxDelay
-			}
-			lambdaPInterval = time.Duration(lambdaPMsec) * time.Millisecond
-			lambdaLMsec := uint64(rand.Exp(mRng, lambdaL))
-			if lambdaLMsec > doc.LambdaLMaxDelay {
-				lambdaLMsec = doc.LambdaLMaxDelay
+			lambdaPInterval = activityInterval(mRng, lambdaP, doc.LambdaPMaxDelay, factor)
+			if s.decoysDisabled() || len(loopServices) == 0 {
+				// The decoy rates may be unset, and must not
+				// schedule events with no delay.
+				lambdaLInterval = time.Duration(maxDuration)
+				lambdaDInterval = time.Duration(maxDuration)
+			} else {
+				lambdaLInterval = activityInterval(mRng, lambdaL, doc.LambdaLMaxDelay, factor)
+				lambdaDInterval = activityInterval(mRng, lambdaD, doc.LambdaDMaxDelay, factor)
 			}
-			lambdaLInterval = time.Duration(lambdaLMsec) * time.Millisecond
-			lambdaDMsec := uint64(rand.Exp(mRng, lambdaD))
-			if lambdaDMsec > doc.LambdaDMaxDelay {
-				lambdaDMsec = doc.LambdaDMaxDelay
-			}
-			lambdaDInterval = time.Duration(lambdaDMsec) * time.Millisecond
 		} else {
 			lambdaLInterval = time.Duration(maxDuration)
 			lambdaPInterval = time.Duration(maxDuration)
@@ -224,35 +219,65 @@ func (s *Session) worker() {
 				lambdaDTimer.Reset(lambdaDInterval)
 			}
 		}
+		if s.workerHook != nil {
+			s.workerHook()
+		}
 	}
 
 	// NOTREACHED
 }
 
+// lambdaPPacket is the kind of packet sent when the LambdaP timer fires.
+type lambdaPPacket int
+
+const (
+	lambdaPNone lambdaPPacket = iota
+	lambdaPRetransmit
+	lambdaPUser
+	lambdaPDropDecoy
+)
+
+// selectLambdaPPacket selects the one packet sent for a LambdaP event.
+// Retransmissions are sent before new user data, and both are held
+// while too many replies are outstanding.  However deep the queues
+// are, at most one packet is sent per event, so that a busy client
+// emits the same schedule as an idle one.
+func selectLambdaPPacket(haveRetransmit, haveQueued, repliesFull, decoysDisabled bool) lambdaPPacket {
+	switch {
+	case haveRetransmit && !repliesFull:
+		return lambdaPRetransmit
+	case haveQueued && !repliesFull:
+		return lambdaPUser
+	case !decoysDisabled:
+		return lambdaPDropDecoy
+	}
+	return lambdaPNone
+}
+
 func (s *Session) sendFromQueueOrDecoy(loopSvc *utils.ServiceDescriptor) {
-	// Attempt to send user data first, if any exists.
-	// Otherwise send a drop decoy message.
 	_, err := s.egressQueue.Peek()
-	if err == nil {
+	switch selectLambdaPPacket(s.retransmitsPending() != 0, err == nil, s.surbIDMap.IsFull(), loopSvc == nil) {
+	case lambdaPRetransmit:
+		s.doRetransmit(s.popRetransmit())
+	case lambdaPUser:
 		s.sendNext()
-	} else if !s.cfg.Debug.DisableDecoyTraffic {
+	case lambdaPDropDecoy:
 		s.sendDropDecoy(loopSvc)
 	}
 }
 
-func (s *Session) isDocValid(doc *pki.Document) error {
-	for _, provider := range doc.Providers {
-		_, ok := provider.Kaetzchen[constants.LoopService]
-		if !ok {
-			return errors.New("found a Provider which does not have the loop service")
-		}
-	}
-	return nil
+// decoysDisabled returns true if decoy traffic is disabled by the
+// configuration or by the document policy.
+func (s *Session) decoysDisabled() bool {
+	return s.cfg.Debug.DisableDecoyTraffic || atomic.LoadUint32(&s.decoysDegraded) != 0
 }
 
-func (s *Session) setPollIntervalFromDoc(doc *pki.Document) {
-	slopFactor := 0.8
-	pollProviderMsec := time.Duration((1.0 / (doc.LambdaP + doc.LambdaL)) * slopFactor * float64(time.Millisecond))
-	s.log.Debugf("onDocument(): setting PollInterval to %s", pollProviderMsec)
-	s.minclient.SetPollInterval(pollProviderMsec)
+func (s *Session) setDecoysDegraded(degraded bool) {
+	var v uint32
+	if degraded {
+		v = 1
+	}
+	if atomic.SwapUint32(&s.decoysDegraded, v) != v {
+		s.log.Noticef("Decoy traffic disabled by the PKI document policy: %v", degraded)
+	}
 }
@@ -0,0 +1,214 @@
+// worker_test.go - Session worker tests.
+// Copyright (C) 2020  The Katzenpost Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	mrand "math/rand"
+	"testing"
+	"time"
+
+	cConstants "github.com/katzenpost/client/constants"
+	"github.com/katzenpost/client/utils"
+	"github.com/katzenpost/core/pki"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var epoch0 = time.Unix(0, 0)
+
+func scheduleDoc() *pki.Document {
+	return &pki.Document{
+		Epoch:           1,
+		LambdaP:         0.01,
+		LambdaPMaxDelay: 10000,
+		LambdaL:         0.005,
+		LambdaLMaxDelay: 10000,
+		LambdaD:         0.005,
+		LambdaDMaxDelay: 10000,
+	}
+}
+
+// startWorker starts the Session's worker, connected, and returns the
+// channel signalled after it handles each event.
+func startWorker(s *Session) chan struct{} {
+	steps := make(chan struct{})
+	s.workerHook = func() {
+		select {
+		case steps <- struct{}{}:
+		case <-s.HaltCh():
+		}
+	}
+	s.Go(func() {
+		s.worker()
+	})
+	s.opCh <- opConnStatusChanged{isConnected: true}
+	<-steps
+	return steps
+}
+
+// runSchedule returns the times at which the first n packets are sent,
+// and their recipients, by a Session with queued messages waiting, whose
+// worker uses the given seed.
+func runSchedule(t *testing.T, seed int64, queued, n int) ([]time.Duration, []string) {
+	clock := NewManualClock(epoch0)
+	doc := scheduleDoc()
+	doc.Providers = loopProviders()
+	mc := &fakeMinclient{doc: doc}
+	s := testSession(t, clock, mc)
+	defer haltTestSession(s)
+	s.scheduleRng = mrand.New(mrand.NewSource(seed))
+
+	sent := []time.Duration{}
+	mc.onSend = func() {
+		sent = append(sent, clock.Now().Sub(epoch0))
+	}
+	for i := 0; i < queued; i++ {
+		require.NoError(t, s.egressQueue.Push(testMessage(byte(i), "bob")))
+	}
+	steps := startWorker(s)
+	for len(sent) < n {
+		require.True(t, clock.Step())
+		<-steps
+	}
+	return sent[:n], mc.sentTo()[:n]
+}
+
+func TestSelectLambdaPPacket(t *testing.T) {
+	assert := assert.New(t)
+
+	// A busy client sends exactly one packet per LambdaP event, just as
+	// an idle one does, whatever is waiting to be sent.
+	for _, haveRetransmit := range []bool{false, true} {
+		for _, haveQueued := range []bool{false, true} {
+			for _, repliesFull := range []bool{false, true} {
+				p := selectLambdaPPacket(haveRetransmit, haveQueued, repliesFull, false)
+				assert.NotEqual(lambdaPNone, p)
+			}
+		}
+	}
+
+	assert.Equal(lambdaPDropDecoy, selectLambdaPPacket(false, false, false, false))
+	assert.Equal(lambdaPRetransmit, selectLambdaPPacket(true, true, false, false))
+	assert.Equal(lambdaPUser, selectLambdaPPacket(false, true, false, false))
+	assert.Equal(lambdaPDropDecoy, selectLambdaPPacket(true, true, true, false))
+	assert.Equal(lambdaPNone, selectLambdaPPacket(true, true, true, true))
+	assert.Equal(lambdaPNone, selectLambdaPPacket(false, false, false, true))
+}
+
+func TestWorkerSchedule(t *testing.T) {
+	require := require.New(t)
+
+	idle, idleTo := runSchedule(t, 1, 0, 64)
+	require.Len(idle, 64)
+	for i := 1; i < len(idle); i++ {
+		require.True(idle[i] >= idle[i-1])
+	}
+	require.NotContains(idleTo, "bob")
+
+	// A busy client sends its messages in place of decoys, at exactly
+	// the times an idle client with the same seed sends.
+	busy, busyTo := runSchedule(t, 1, 8, 64)
+	require.Equal(idle, busy)
+	n := 0
+	for _, recipient := range busyTo {
+		if recipient == "bob" {
+			n++
+		}
+	}
+	require.Equal(8, n)
+
+	// The schedule is reproducible from the seed alone.
+	again, _ := runSchedule(t, 1, 8, 64)
+	require.Equal(busy, again)
+	other, _ := runSchedule(t, 2, 8, 64)
+	require.NotEqual(busy, other)
+}
+
+func loopProviders() []*pki.MixDescriptor {
+	return []*pki.MixDescriptor{{
+		Name: "acme",
+		Kaetzchen: map[string]map[string]interface{}{
+			cConstants.LoopService: {"endpoint": "loop"},
+		},
+	}}
+}
+
+// dueWithin returns the number of timers due within d.
+func dueWithin(c *ManualClock, d time.Duration) int {
+	c.Lock()
+	defer c.Unlock()
+	n := 0
+	for _, t := range c.timers {
+		if t.deadline.Before(c.now.Add(d)) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestWorkerDecoysDisabledByDocument(t *testing.T) {
+	require := require.New(t)
+
+	// A document without decoy rates only disables decoys.
+	doc := scheduleDoc()
+	doc.LambdaL, doc.LambdaLMaxDelay = 0, 0
+	doc.LambdaD, doc.LambdaDMaxDelay = 0, 0
+	doc.Providers = loopProviders()
+
+	clock := NewManualClock(epoch0)
+	mc := &fakeMinclient{doc: doc}
+	s := testSession(t, clock, mc)
+	defer haltTestSession(s)
+	require.NoError(s.checkDoc(doc))
+	require.True(s.decoysDisabled())
+
+	// Only the LambdaP timer is scheduled, the decoy timers must not
+	// fire without delay and spin the worker.
+	steps := startWorker(s)
+	require.Equal(1, dueWithin(clock, time.Hour))
+	for i := 0; i < 16; i++ {
+		require.True(clock.Step())
+		<-steps
+		require.Equal(1, dueWithin(clock, time.Hour))
+	}
+	require.Empty(mc.sentTo())
+}
+
+func TestWorkerSkipsNilDecoyService(t *testing.T) {
+	require := require.New(t)
+
+	doc := scheduleDoc()
+	doc.Providers = loopProviders()
+	clock := NewManualClock(epoch0)
+	mc := &fakeMinclient{doc: doc}
+	s := testSession(t, clock, mc)
+	defer haltTestSession(s)
+	selected := 0
+	s.decoyServiceFn = func([]utils.ServiceDescriptor) *utils.ServiceDescriptor {
+		selected++
+		return nil
+	}
+
+	// Every decoy is skipped, whichever of the timers fires.
+	steps := startWorker(s)
+	for i := 0; i < 32; i++ {
+		require.True(clock.Step())
+		<-steps
+	}
+	require.Equal(32, selected)
+	require.Empty(mc.sentTo())
+}